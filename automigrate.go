@@ -13,4 +13,24 @@ func AutoMigrate(db *gorm.DB) {
 	if err != nil {
 		panic(err)
 	}
+	err = db.AutoMigrate(&CardFace{})
+	if err != nil {
+		panic(err)
+	}
+	err = db.AutoMigrate(&OracleCard{})
+	if err != nil {
+		panic(err)
+	}
+	err = db.AutoMigrate(&CardPrice{})
+	if err != nil {
+		panic(err)
+	}
+	err = db.AutoMigrate(&CardRelation{})
+	if err != nil {
+		panic(err)
+	}
+	err = db.AutoMigrate(&CardTranslation{})
+	if err != nil {
+		panic(err)
+	}
 }
@@ -0,0 +1,239 @@
+package mtgdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// bulkDataMetadataFileName is the file, inside the data dir, where are stored
+// the Scryfall bulk data entries of the downloaded bulk data files.
+const bulkDataMetadataFileName = "bulk_data.json"
+
+// bulkDataMetadata is the Scryfall bulk data entry of a downloaded bulk data
+// file together with the size of the file on disk.
+type bulkDataMetadata struct {
+	bulkDataJsonStruct
+	FileSize int64 `json:"file_size"`
+}
+
+func loadBulkDataMetadata(dataDir string) (map[string]bulkDataMetadata, error) {
+	metadata := make(map[string]bulkDataMetadata)
+	err := loadFile(filepath.Join(dataDir, bulkDataMetadataFileName), &metadata)
+	if os.IsNotExist(err) {
+		return metadata, nil
+	}
+	return metadata, err
+}
+
+func saveBulkDataMetadata(dataDir string, metadata map[string]bulkDataMetadata) error {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Replace the file, do not overwrite it, since it can be linked in a
+	// snapshot
+	return writeFileAtomically(filepath.Join(dataDir, bulkDataMetadataFileName), bytes.NewReader(data), int64(len(data)))
+}
+
+// isBulkDataFileUpToDate returns true if the bulk data file at filePath,
+// downloaded from the entry local, is still the same of the entry remote
+// currently published by Scryfall.
+func isBulkDataFileUpToDate(filePath string, local bulkDataMetadata, remote bulkDataJsonStruct) bool {
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return false
+	}
+	if local.UpdatedAt == "" || local.UpdatedAt != remote.UpdatedAt {
+		return false
+	}
+	return local.FileSize == 0 || stat.Size() == local.FileSize
+}
+
+// bulkDataPart describes a partially downloaded bulk data file. It is stored
+// as JSON next to the part file to resume the download.
+type bulkDataPart struct {
+	Url             string `json:"url"`
+	Validator       string `json:"validator"`
+	ContentEncoding string `json:"content_encoding"`
+}
+
+// downloadBulkFile downloads url into filePath. The body is downloaded, gzip
+// compressed if the server supports it, into filePath.part and each retry, or
+// a later call after a failure, resumes the download from where it stopped
+// using a HTTP range request. Once complete the part file is compressed or
+// decompressed according to importer.CompressBulkData and renamed to
+// filePath.
+func (importer *Importer) downloadBulkFile(ctx context.Context, filePath, url string) error {
+	partFilePath := filePath + ".part"
+	err := retryOnError(ctx, importer.RetryAttempts, importer.RetryDelay, func() error {
+		return importer.downloadBulkFilePart(ctx, partFilePath, url)
+	})
+	if err != nil {
+		return err
+	}
+
+	var part bulkDataPart
+	err = loadFile(partFilePath+".json", &part)
+	if err != nil {
+		return err
+	}
+	err = finalizeBulkFile(partFilePath, filePath, part.ContentEncoding == "gzip", importer.CompressBulkData)
+	if err != nil {
+		return err
+	}
+	return os.Remove(partFilePath + ".json")
+}
+
+func (importer *Importer) downloadBulkFilePart(ctx context.Context, partFilePath, url string) error {
+	var (
+		part   bulkDataPart
+		offset int64
+	)
+	err := loadFile(partFilePath+".json", &part)
+	// Without a validator the server can not tell if the part file is of the
+	// same version of the file
+	if err == nil && part.Url == url && part.Validator != "" {
+		if stat, err := os.Stat(partFilePath); err == nil {
+			offset = stat.Size()
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	// Setting Accept-Encoding disables the transparent decompression of the
+	// transport, so the body is stored as it is sent over the wire and ranges
+	// refer always to the same bytes.
+	req.Header.Set("Accept-Encoding", "gzip")
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", part.Validator)
+	}
+	resp, err := importer.doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	resumed := resp.StatusCode == http.StatusPartialContent && contentRangeStart(resp.Header) == offset && resp.Header.Get("Content-Encoding") == part.ContentEncoding
+	if offset > 0 && !resumed && (resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable) {
+		// The range does not continue the part file, or the part file is
+		// already complete: start again from scratch
+		resp.Body.Close()
+		os.Remove(partFilePath + ".json")
+		return importer.downloadBulkFilePart(ctx, partFilePath, url)
+	}
+	switch {
+	case offset > 0 && resumed:
+		log.Printf("Resume download of `%s` from byte %d\n", url, offset)
+		flags = os.O_WRONLY | os.O_APPEND
+	case resp.StatusCode == http.StatusOK:
+		part = bulkDataPart{Url: url, Validator: responseValidator(resp.Header), ContentEncoding: resp.Header.Get("Content-Encoding")}
+		data, err := json.Marshal(part)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(partFilePath+".json", data, 0644)
+		if err != nil {
+			return err
+		}
+	default:
+		// Start again from scratch on the next attempt
+		os.Remove(partFilePath + ".json")
+		return httpError(url, resp)
+	}
+
+	file, err := os.OpenFile(partFilePath, flags, 0644)
+	if err != nil {
+		return err
+	}
+	written, err := io.Copy(file, resp.Body)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// contentRangeStart returns the first byte of the Content-Range header, or -1
+// if the header is missing or not valid.
+func contentRangeStart(header http.Header) int64 {
+	var start, end int64
+	var total string
+	_, err := fmt.Sscanf(header.Get("Content-Range"), "bytes %d-%d/%s", &start, &end, &total)
+	if err != nil {
+		return -1
+	}
+	return start
+}
+
+// responseValidator returns the value to use in the If-Range header to resume
+// the download of the response with header.
+func responseValidator(header http.Header) string {
+	etag := header.Get("ETag")
+	if etag != "" && etag[0] == '"' {
+		return etag
+	}
+	return header.Get("Last-Modified")
+}
+
+// finalizeBulkFile atomically moves the part file at partFilePath to
+// filePath, compressing or decompressing it when gzipped is different from
+// compress.
+func finalizeBulkFile(partFilePath, filePath string, gzipped, compress bool) error {
+	if gzipped == compress {
+		return os.Rename(partFilePath, filePath)
+	}
+
+	partFile, err := os.Open(partFilePath)
+	if err != nil {
+		return err
+	}
+	defer partFile.Close()
+	var reader io.Reader
+	if gzipped {
+		gzipReader, err := gzip.NewReader(partFile)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	} else {
+		pipeReader, pipeWriter := io.Pipe()
+		go func() {
+			gzipWriter := gzip.NewWriter(pipeWriter)
+			_, err := io.Copy(gzipWriter, partFile)
+			if err == nil {
+				err = gzipWriter.Close()
+			}
+			pipeWriter.CloseWithError(err)
+		}()
+		defer pipeReader.Close()
+		reader = pipeReader
+	}
+	err = writeFileAtomically(filePath, reader, -1)
+	if err != nil {
+		return err
+	}
+	return os.Remove(partFilePath)
+}
@@ -8,6 +8,10 @@ import (
 	"time"
 )
 
+// Card is a printing of a card. Legalities and Rulings are the same for all
+// the printings and are stored once in the OracleCard (see FindOracleCard):
+// the copies in Card are deprecated, kept only for compatibility, and will be
+// removed.
 type Card struct {
 	ID uint `gorm:"primary_key"`
 
@@ -59,7 +63,7 @@ type Card struct {
 	Keywords           SliceString `gorm:"type:json"`
 	Layout             string      `gorm:"size:255"`
 	LayoutBack         string      `gorm:"size:255"`
-	Legalities         MapString   `gorm:"type:json"`
+	Legalities         MapString   `gorm:"type:json"` // Deprecated: use the OracleCard Legalities
 	LifeModifier       string      `gorm:"size:255"`
 	Loyalty            string      `gorm:"size:255"`
 	LoyaltyBack        string      `gorm:"size:255"`
@@ -86,14 +90,22 @@ type Card struct {
 	Watermark          string `gorm:"size:255"`
 	WatermarkBack      string `gorm:"size:255"`
 
-	ScryfallID   string `gorm:"size:255;not null"`
-	OracleID     string `gorm:"size:255"`
+	ScryfallID   string `gorm:"size:255;not null;index"`
+	OracleID     string `gorm:"size:255;index"`
 	MtgoID       uint64
 	ArenaID      uint64
 	TcgplayerID  uint64
 	CardmarketID uint64
 
+	// Deprecated: use the OracleCard Rulings.
 	Rulings Rulings `gorm:"type:json"`
+
+	Faces        []CardFace        `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Relations    []CardRelation    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Translations []CardTranslation `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+
+	// Prices of the import, stored in the card_prices table by BulkInsertPrices
+	Price *CardPrice `gorm:"-"`
 }
 
 func (card *Card) IsValid() bool {
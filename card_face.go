@@ -0,0 +1,43 @@
+package mtgdb
+
+import "fmt"
+
+// CardFace is a face of a card with more than one face, like transform, meld,
+// split or flip cards. Faces are ordered by Ordinal starting from 0.
+type CardFace struct {
+	ID uint `gorm:"primary_key"`
+
+	CardID  uint `gorm:"not null;uniqueIndex:idx_card_faces_card_id_ordinal"`
+	Ordinal int  `gorm:"not null;uniqueIndex:idx_card_faces_card_id_ordinal"`
+
+	Name           string `gorm:"size:255;not null"`
+	Artist         string `gorm:"size:255"`
+	CMC            float32
+	ColorIndicator SliceString `gorm:"type:json"`
+	Colors         SliceString `gorm:"type:json"`
+	FlavorText     string
+	Layout         string `gorm:"size:255"`
+	Loyalty        string `gorm:"size:255"`
+	ManaCost       string `gorm:"size:255"`
+	OracleText     string
+	Power          string `gorm:"size:255"`
+	Toughness      string `gorm:"size:255"`
+	TypeLine       string `gorm:"size:255"`
+	Watermark      string `gorm:"size:255"`
+	ImageUrl       string `gorm:"size:255"`
+}
+
+// CardFaceImagePath returns the path of the image of the face with ordinal
+// of a card. The first two faces use the same paths of the front and back
+// images of the card.
+func CardFaceImagePath(imagesDir, setCode, collectorNumber, locale string, ordinal int) string {
+	if ordinal < 2 {
+		return CardImagePath(imagesDir, setCode, collectorNumber, locale, ordinal == 1)
+	}
+	path := CardImagePath(imagesDir, setCode, collectorNumber, locale, false)
+	return fmt.Sprintf("%s_face%d.jpg", path[:len(path)-len(".jpg")], ordinal)
+}
+
+func (card *Card) FaceImagePath(dataImagesPath, locale string, ordinal int) string {
+	return CardFaceImagePath(dataImagesPath, card.SetCode, card.CollectorNumber, locale, ordinal)
+}
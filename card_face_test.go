@@ -0,0 +1,104 @@
+package mtgdb_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pioz/mtgdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImporterCardFaces(t *testing.T) {
+	defer os.RemoveAll(TEMP_DIR)
+	allCardsJson, err := ioutil.ReadFile(filepath.Join(FIXTURES_PATH, "data", "all_cards.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Add a third face to the english Daybreak Ranger and move it after the
+	// other languages
+	cardsJson := make([]map[string]interface{}, 0)
+	err = json.Unmarshal(allCardsJson, &cardsJson)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, cardJson := range cardsJson {
+		if cardJson["set"] == "isd" && cardJson["lang"] == "en" {
+			faces := cardJson["card_faces"].([]interface{})
+			cardJson["card_faces"] = append(faces, map[string]interface{}{
+				"name":       "Third Face",
+				"type_line":  "Creature — Werewolf",
+				"image_uris": map[string]interface{}{"normal": "https://img.scryfall.com/third.jpg"},
+			})
+			cardsJson = append(append(cardsJson[:i], cardsJson[i+1:]...), cardJson)
+			break
+		}
+	}
+	allCardsJson, err = json.Marshal(cardsJson)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataDir := writeDataDir(t, string(allCardsJson))
+
+	importer := mtgdb.NewImporter(dataDir)
+	importer.DownloadAssets = false
+	collection, _, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, card := range collection {
+		switch card.SetCode + "-" + card.CollectorNumber {
+		case "isd-176":
+			assert.Equal(t, 3, len(card.Faces))
+			for i, face := range card.Faces {
+				assert.Equal(t, i, face.Ordinal)
+			}
+			assert.Equal(t, "Daybreak Ranger", card.Faces[0].Name)
+			assert.Equal(t, "Nightfall Predator", card.Faces[1].Name)
+			assert.Equal(t, "Third Face", card.Faces[2].Name)
+			assert.Equal(t, "Creature — Werewolf", card.Faces[2].TypeLine)
+			assert.Equal(t, "https://img.scryfall.com/third.jpg", card.Faces[2].ImageUrl)
+			assert.Equal(t, card.FrontImageUrl, card.Faces[0].ImageUrl)
+			assert.Equal(t, card.BackImageUrl, card.Faces[1].ImageUrl)
+			// Legacy columns keep the first two faces
+			assert.Equal(t, card.Faces[1].TypeLine, card.TypeLineBack)
+		case "sld-1675":
+			assert.Equal(t, 2, len(card.Faces))
+			assert.Equal(t, "Birds of Paradise", card.Faces[1].Name)
+		default:
+			assert.Empty(t, card.Faces)
+		}
+	}
+
+	// The faces of the english row are streamed as update of the card
+	var streamedFaces []mtgdb.CardFace
+	_, err = importer.StreamCardsFromJson(1, func(cards, updates []mtgdb.Card, oracleCards []mtgdb.OracleCard) error {
+		for _, card := range append(cards, updates...) {
+			if card.SetCode+"-"+card.CollectorNumber == "isd-176" && card.Faces != nil {
+				streamedFaces = card.Faces
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, card := range collection {
+		if card.SetCode+"-"+card.CollectorNumber == "isd-176" {
+			assert.Equal(t, card.Faces, streamedFaces)
+		}
+	}
+}
+
+func TestCardFaceImagePath(t *testing.T) {
+	card := mtgdb.Card{
+		EnName:          "Who // What // When // Where // Why",
+		CollectorNumber: "119",
+		SetCode:         "unh",
+	}
+	assert.Equal(t, "images/cards/unh/unh_119_en.jpg", card.FaceImagePath("./images", "en", 0))
+	assert.Equal(t, "images/cards/unh/unh_119_en_back.jpg", card.FaceImagePath("./images", "en", 1))
+	assert.Equal(t, "images/cards/unh/unh_119_en_face2.jpg", card.FaceImagePath("./images", "en", 2))
+}
@@ -0,0 +1,135 @@
+package mtgdb
+
+import (
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CardPrice are the prices of a card on Date, as reported by Scryfall. Each
+// import stores a new row so the rows of a card are its price history. A nil
+// price is not available.
+type CardPrice struct {
+	ID uint `gorm:"primary_key"`
+
+	CardID uint      `gorm:"not null;uniqueIndex:idx_card_prices_card_id_date"`
+	Date   time.Time `gorm:"type:date;not null;uniqueIndex:idx_card_prices_card_id_date"`
+
+	Usd       *float64 `gorm:"type:decimal(12,2)"`
+	UsdFoil   *float64 `gorm:"type:decimal(12,2)"`
+	UsdEtched *float64 `gorm:"type:decimal(12,2)"`
+	Eur       *float64 `gorm:"type:decimal(12,2)"`
+	EurFoil   *float64 `gorm:"type:decimal(12,2)"`
+	Tix       *float64 `gorm:"type:decimal(12,2)"`
+}
+
+type pricesCardJsonStruct struct {
+	Usd       *string `json:"usd"`
+	UsdFoil   *string `json:"usd_foil"`
+	UsdEtched *string `json:"usd_etched"`
+	Eur       *string `json:"eur"`
+	EurFoil   *string `json:"eur_foil"`
+	Tix       *string `json:"tix"`
+}
+
+// newCardPrice returns the prices on date or nil if there are no prices.
+func (pricesJson *pricesCardJsonStruct) newCardPrice(date time.Time) *CardPrice {
+	price := &CardPrice{
+		Date:      date,
+		Usd:       parsePrice(pricesJson.Usd),
+		UsdFoil:   parsePrice(pricesJson.UsdFoil),
+		UsdEtched: parsePrice(pricesJson.UsdEtched),
+		Eur:       parsePrice(pricesJson.Eur),
+		EurFoil:   parsePrice(pricesJson.EurFoil),
+		Tix:       parsePrice(pricesJson.Tix),
+	}
+	if price.Usd == nil && price.UsdFoil == nil && price.UsdEtched == nil && price.Eur == nil && price.EurFoil == nil && price.Tix == nil {
+		return nil
+	}
+	return price
+}
+
+func parsePrice(value *string) *float64 {
+	if value == nil {
+		return nil
+	}
+	price, err := strconv.ParseFloat(*value, 64)
+	if err != nil {
+		return nil
+	}
+	return &price
+}
+
+// currentPricesDate returns the day of the prices: the day of the snapshot,
+// if any, or today.
+func (importer *Importer) currentPricesDate() time.Time {
+	date := time.Now().UTC()
+	if importer.Snapshot != "" {
+		snapshotTime, err := SnapshotTime(importer.Snapshot)
+		if err == nil {
+			date = snapshotTime
+		}
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// BulkInsertPrices stores the Price of cards, replacing the prices of the same
+// cards on the same day. Cards must be already stored in db.
+func BulkInsertPrices(db *gorm.DB, cards []Card) error {
+	withPrice := make([]Card, 0)
+	for _, card := range cards {
+		if card.Price != nil {
+			withPrice = append(withPrice, card)
+		}
+	}
+	if len(withPrice) == 0 {
+		return nil
+	}
+	ids, err := storedCardIds(db, withPrice)
+	if err != nil {
+		return err
+	}
+	prices := make([]CardPrice, 0, len(withPrice))
+	for _, card := range withPrice {
+		id, found := ids[card.SetCode+"-"+card.CollectorNumber]
+		if !found {
+			continue
+		}
+		price := *card.Price
+		price.ID = 0
+		price.CardID = id
+		prices = append(prices, price)
+	}
+	if len(prices) == 0 {
+		return nil
+	}
+	scope := db.Clauses(clause.OnConflict{UpdateAll: true}).Session(&gorm.Session{CreateBatchSize: 500})
+	return scope.Create(prices).Error
+}
+
+// LatestPrice returns the most recent prices of the stored card.
+func (card *Card) LatestPrice(db *gorm.DB) (*CardPrice, error) {
+	price := &CardPrice{}
+	err := db.Where("card_id = ?", card.ID).Order("date DESC").First(price).Error
+	if err != nil {
+		return nil, err
+	}
+	return price, nil
+}
+
+// PriceHistory returns the prices of the stored card between from and to,
+// included, ordered by date. Zero times are not used as limits.
+func (card *Card) PriceHistory(db *gorm.DB, from, to time.Time) ([]CardPrice, error) {
+	prices := make([]CardPrice, 0)
+	scope := db.Where("card_id = ?", card.ID)
+	if !from.IsZero() {
+		scope = scope.Where("date >= ?", from)
+	}
+	if !to.IsZero() {
+		scope = scope.Where("date <= ?", to)
+	}
+	err := scope.Order("date").Find(&prices).Error
+	return prices, err
+}
@@ -0,0 +1,149 @@
+package mtgdb_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pioz/mtgdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImporterCardPrices(t *testing.T) {
+	importer := mtgdb.NewImporter(filepath.Join(FIXTURES_PATH, "data"))
+	importer.DownloadAssets = false
+	collection, _, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	for _, card := range collection {
+		switch card.SetCode + "-" + card.CollectorNumber {
+		case "sld-1675":
+			if assert.NotNil(t, card.Price) {
+				assert.Equal(t, 24.46, *card.Price.Usd)
+			}
+		case "war-169★":
+			// Prices of a card without an english printing
+			if assert.NotNil(t, card.Price) {
+				assert.Equal(t, today, card.Price.Date)
+				assert.Equal(t, 15.93, *card.Price.Usd)
+				assert.Equal(t, 260.80, *card.Price.UsdFoil)
+				assert.Nil(t, card.Price.UsdEtched)
+				assert.Nil(t, card.Price.Eur)
+				assert.Nil(t, card.Price.EurFoil)
+				assert.Nil(t, card.Price.Tix)
+			}
+		default:
+			assert.Nil(t, card.Price)
+		}
+	}
+}
+
+func TestDBCardPrices(t *testing.T) {
+	defer os.RemoveAll(TEMP_DIR)
+	db := openTestDB(t)
+	allCardsJson, err := ioutil.ReadFile(filepath.Join(FIXTURES_PATH, "data", "all_cards.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataDir := filepath.Join(TEMP_DIR, "data")
+	writeSnapshot(t, dataDir, "20200101T120000Z", string(allCardsJson))
+	writeSnapshot(t, dataDir, "20200102T120000Z", strings.Replace(string(allCardsJson), `"usd": "24.46"`, `"usd": "30.00"`, 1))
+	day1 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	day2 := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	importer := mtgdb.NewImporter(dataDir)
+	importer.DownloadAssets = false
+	streamed := false
+	writeCards := func(cards, updates []mtgdb.Card, oracleCards []mtgdb.OracleCard) error {
+		delta, err := mtgdb.ComputeDelta(db, cards, updates, streamed)
+		if err != nil {
+			return err
+		}
+		err = mtgdb.ApplyDelta(db, delta)
+		if err != nil {
+			return err
+		}
+		err = mtgdb.BulkInsertPrices(db, cards)
+		if err != nil {
+			return err
+		}
+		return mtgdb.BulkInsertPrices(db, updates)
+	}
+
+	// The first day twice: the prices of the same day are replaced
+	importer.Snapshot = "20200101T120000Z"
+	for i := 0; i < 2; i++ {
+		collection, _, err := importer.BuildCardsFromJson()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = writeCards(collection, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var translationsCount int64
+	db.Model(&mtgdb.CardTranslation{}).Count(&translationsCount)
+	assert.NotZero(t, translationsCount)
+
+	// The second day streamed, with partial updates
+	importer.Snapshot = "20200102T120000Z"
+	streamed = true
+	_, err = importer.StreamCardsFromJson(3, writeCards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Model(&mtgdb.CardTranslation{}).Count(&count)
+	assert.Equal(t, translationsCount, count)
+
+	var card mtgdb.Card
+	err = db.Where("set_code = ? AND collector_number = ?", "sld", "1675").First(&card).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Model(&mtgdb.CardPrice{}).Where("card_id = ?", card.ID).Count(&count)
+	assert.Equal(t, int64(2), count)
+
+	price, err := card.LatestPrice(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, day2.Equal(price.Date), price.Date)
+	assert.Equal(t, 30.00, *price.Usd)
+
+	prices, err := card.PriceHistory(db, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equal(t, 2, len(prices)) {
+		assert.True(t, day1.Equal(prices[0].Date), prices[0].Date)
+		assert.Equal(t, 24.46, *prices[0].Usd)
+		assert.True(t, day2.Equal(prices[1].Date), prices[1].Date)
+	}
+	prices, err = card.PriceHistory(db, day2, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equal(t, 1, len(prices)) {
+		assert.Equal(t, 30.00, *prices[0].Usd)
+	}
+	prices, err = card.PriceHistory(db, time.Time{}, day1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equal(t, 1, len(prices)) {
+		assert.Equal(t, 24.46, *prices[0].Usd)
+	}
+	prices, err = card.PriceHistory(db, day2.AddDate(0, 0, 1), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, prices)
+}
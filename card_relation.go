@@ -0,0 +1,83 @@
+package mtgdb
+
+import (
+	"gorm.io/gorm"
+)
+
+// CardRelation links a card to a related card listed by Scryfall in its
+// all_parts, like the tokens it creates or its meld result. Component is the
+// kind of the related card: token, meld_part, meld_result or combo_piece.
+// RelatedCardID is the stored related card, nil until ResolveCardRelations
+// finds it.
+type CardRelation struct {
+	ID uint `gorm:"primary_key"`
+
+	CardID   uint   `gorm:"not null;uniqueIndex:idx_card_relations_card_id_related_scryfall_id"`
+	OracleID string `gorm:"size:255;index"`
+
+	Component         string `gorm:"size:255;not null"`
+	RelatedScryfallID string `gorm:"size:255;not null;uniqueIndex:idx_card_relations_card_id_related_scryfall_id"`
+	RelatedName       string `gorm:"size:255;not null"`
+	RelatedTypeLine   string `gorm:"size:255"`
+	RelatedCardID     *uint  `gorm:"index"`
+}
+
+type relatedCardJsonStruct struct {
+	ScryfallID string `json:"id"`
+	Component  string `json:"component"`
+	Name       string `json:"name"`
+	TypeLine   string `json:"type_line"`
+}
+
+func buildCardRelations(cardJson *cardJsonStruct) []CardRelation {
+	if len(cardJson.AllParts) == 0 {
+		return nil
+	}
+	relations := make([]CardRelation, 0, len(cardJson.AllParts))
+	for _, part := range cardJson.AllParts {
+		// Scryfall lists also the card itself
+		if part.ScryfallID == cardJson.ScryfallID {
+			continue
+		}
+		relations = append(relations, CardRelation{
+			OracleID:          cardJson.OracleID,
+			Component:         part.Component,
+			RelatedScryfallID: part.ScryfallID,
+			RelatedName:       part.Name,
+			RelatedTypeLine:   part.TypeLine,
+		})
+	}
+	return relations
+}
+
+// ResolveCardRelations sets the RelatedCardID of the stored relations to the
+// stored card with the related Scryfall ID. Call it after all cards of an
+// import are stored.
+func ResolveCardRelations(db *gorm.DB) error {
+	return db.Exec("UPDATE card_relations JOIN cards ON cards.scryfall_id = card_relations.related_scryfall_id " +
+		"SET card_relations.related_card_id = cards.id " +
+		"WHERE card_relations.related_card_id IS NULL OR card_relations.related_card_id != cards.id").Error
+}
+
+// RelatedCards returns the stored cards related to the stored card, or to any
+// printing of it, with one of components. With no components all related
+// cards are returned.
+func (card *Card) RelatedCards(db *gorm.DB, components ...string) ([]Card, error) {
+	related := make([]Card, 0)
+	relations := db.Model(&CardRelation{}).Select("related_card_id").Where("related_card_id IS NOT NULL")
+	if card.OracleID != "" {
+		relations = relations.Where("oracle_id = ?", card.OracleID)
+	} else {
+		relations = relations.Where("card_id = ?", card.ID)
+	}
+	if len(components) > 0 {
+		relations = relations.Where("component IN ?", components)
+	}
+	err := db.Where("id IN (?)", relations).Order("released_at, set_code, collector_number").Find(&related).Error
+	return related, err
+}
+
+// Tokens returns the stored tokens that the card creates.
+func (card *Card) Tokens(db *gorm.DB) ([]Card, error) {
+	return card.RelatedCards(db, "token")
+}
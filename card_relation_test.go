@@ -0,0 +1,99 @@
+package mtgdb_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/pioz/mtgdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImporterCardRelations(t *testing.T) {
+	importer := mtgdb.NewImporter(filepath.Join(FIXTURES_PATH, "data"))
+	importer.DownloadAssets = false
+	collection, _, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, card := range collection {
+		switch card.SetCode + "-" + card.CollectorNumber {
+		case "eld-191":
+			// The card itself is not a relation
+			if assert.Equal(t, 2, len(card.Relations)) {
+				assert.Equal(t, "token", card.Relations[0].Component)
+				assert.Equal(t, "88452ed7-1065-41c3-94a6-dc41108c45c1", card.Relations[0].RelatedScryfallID)
+				assert.Equal(t, "Wolf", card.Relations[0].RelatedName)
+				assert.Equal(t, "Token Creature — Wolf", card.Relations[0].RelatedTypeLine)
+				assert.Equal(t, card.OracleID, card.Relations[0].OracleID)
+				assert.Nil(t, card.Relations[0].RelatedCardID)
+				assert.Equal(t, "combo_piece", card.Relations[1].Component)
+				assert.Equal(t, "d6c65749-1774-4b36-891e-abf762c95cec", card.Relations[1].RelatedScryfallID)
+			}
+		case "teld-19":
+			if assert.Equal(t, 1, len(card.Relations)) {
+				assert.Equal(t, "Garruk, Cursed Huntsman", card.Relations[0].RelatedName)
+			}
+		default:
+			assert.Empty(t, card.Relations)
+		}
+	}
+}
+
+func TestDBCardRelations(t *testing.T) {
+	db := openTestDB(t)
+	importer := mtgdb.NewImporter(filepath.Join(FIXTURES_PATH, "data"))
+	importer.DownloadAssets = false
+	importer.OnlyTheseSetCodes = []string{"eld", "teld"}
+	collection, _, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The Wolf token created by eld-191 is not in the fixture
+	var emblem mtgdb.Card
+	for _, card := range collection {
+		if card.SetCode == "teld" {
+			emblem = card
+		}
+	}
+	wolf := mtgdb.Card{
+		EnName:          "Wolf",
+		SetCode:         "teld",
+		CollectorNumber: "6",
+		ScryfallID:      "88452ed7-1065-41c3-94a6-dc41108c45c1",
+		Set:             emblem.Set,
+	}
+	err = mtgdb.BulkInsert(db, append(collection, wolf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mtgdb.ResolveCardRelations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var card mtgdb.Card
+	err = db.Where("set_code = ? AND collector_number = ?", "eld", "191").First(&card).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := card.Tokens(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equal(t, 1, len(tokens)) {
+		assert.Equal(t, "Wolf", tokens[0].EnName)
+		assert.Equal(t, wolf.ScryfallID, tokens[0].ScryfallID)
+	}
+	related, err := card.RelatedCards(db, "combo_piece")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equal(t, 1, len(related)) {
+		assert.Equal(t, emblem.ScryfallID, related[0].ScryfallID)
+	}
+	related, err = card.RelatedCards(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(related))
+}
@@ -0,0 +1,119 @@
+package mtgdb
+
+import (
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CardTranslation is the text of a card printed in a language other than
+// English, as Scryfall codes it (es, ja, he, la, ph...). Texts of cards with
+// more faces are joined with " // ".
+type CardTranslation struct {
+	ID uint `gorm:"primary_key"`
+
+	CardID uint   `gorm:"not null;uniqueIndex:idx_card_translations_card_id_lang"`
+	Lang   string `gorm:"size:255;not null;uniqueIndex:idx_card_translations_card_id_lang"`
+
+	PrintedName     string `gorm:"size:255"`
+	PrintedTypeLine string `gorm:"size:255"`
+	PrintedText     string
+	FlavorText      string
+	ScryfallID      string `gorm:"size:255;not null;index"`
+	ImageUrl        string `gorm:"size:255"`
+}
+
+// Translation returns the translation of card in lang or nil if there is not.
+func (card *Card) Translation(lang string) *CardTranslation {
+	for i := range card.Translations {
+		if card.Translations[i].Lang == lang {
+			return &card.Translations[i]
+		}
+	}
+	return nil
+}
+
+// setTranslation adds translation to card, replacing the one in the same
+// language. Translations are kept sorted by language.
+func (card *Card) setTranslation(translation CardTranslation) {
+	if current := card.Translation(translation.Lang); current != nil {
+		*current = translation
+		return
+	}
+	card.Translations = append(card.Translations, translation)
+	sort.Slice(card.Translations, func(i, j int) bool {
+		return card.Translations[i].Lang < card.Translations[j].Lang
+	})
+}
+
+func (importer *Importer) buildCardTranslation(cardJson *cardJsonStruct, printedName string) CardTranslation {
+	translation := CardTranslation{
+		Lang:            cardJson.Lang,
+		PrintedName:     printedName,
+		PrintedTypeLine: cardJson.PrintedTypeLine,
+		PrintedText:     cardJson.PrintedText,
+		FlavorText:      cardJson.FlavorText,
+		ScryfallID:      cardJson.ScryfallID,
+		ImageUrl:        cardJson.getImageUrls(importer.ImageType)[0],
+	}
+	if len(cardJson.CardFaces) > 1 {
+		typeLines := make([]string, 0, len(cardJson.CardFaces))
+		texts := make([]string, 0, len(cardJson.CardFaces))
+		flavorTexts := make([]string, 0, len(cardJson.CardFaces))
+		for _, face := range cardJson.CardFaces {
+			typeLines = append(typeLines, face.PrintedTypeLine)
+			texts = append(texts, face.PrintedText)
+			flavorTexts = append(flavorTexts, face.FlavorText)
+		}
+		if translation.PrintedTypeLine == "" {
+			translation.PrintedTypeLine = joinFacesText(typeLines)
+		}
+		if translation.PrintedText == "" {
+			translation.PrintedText = joinFacesText(texts)
+		}
+		if translation.FlavorText == "" {
+			translation.FlavorText = joinFacesText(flavorTexts)
+		}
+	}
+	return translation
+}
+
+// joinFacesText joins the texts of the faces of a card, or returns an empty
+// string if all are empty.
+func joinFacesText(texts []string) string {
+	for _, text := range texts {
+		if text != "" {
+			return strings.Join(texts, " // ")
+		}
+	}
+	return ""
+}
+
+// saveCardTranslations inserts the translations of the partial updates in
+// cards, updating the ones in the same language. The other translations of
+// the cards are kept.
+func saveCardTranslations(db *gorm.DB, cards []Card) error {
+	ids, err := storedCardIds(db, cards)
+	if err != nil {
+		return err
+	}
+	translations := make([]CardTranslation, 0)
+	for _, card := range cards {
+		id, found := ids[card.SetCode+"-"+card.CollectorNumber]
+		if !found {
+			continue
+		}
+		for _, translation := range card.Translations {
+			translation.ID = 0
+			translation.CardID = id
+			translations = append(translations, translation)
+		}
+	}
+	if len(translations) == 0 {
+		return nil
+	}
+	scope := db.Clauses(clause.OnConflict{UpdateAll: true}).Session(&gorm.Session{CreateBatchSize: 500})
+	return scope.Create(translations).Error
+}
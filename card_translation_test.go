@@ -0,0 +1,80 @@
+package mtgdb_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pioz/mtgdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImporterCardTranslations(t *testing.T) {
+	defer os.RemoveAll(TEMP_DIR)
+	allCardsJson, err := ioutil.ReadFile(filepath.Join(FIXTURES_PATH, "data", "all_cards.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Add a Phyrexian Acclaimed Contender
+	cardsJson := make([]map[string]interface{}, 0)
+	err = json.Unmarshal(allCardsJson, &cardsJson)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cardJson := range cardsJson {
+		if cardJson["set"] == "eld" && cardJson["collector_number"] == "1" && cardJson["lang"] == "de" {
+			phCardJson := make(map[string]interface{})
+			for key, value := range cardJson {
+				phCardJson[key] = value
+			}
+			phCardJson["lang"] = "ph"
+			phCardJson["id"] = "ph-eld-1"
+			phCardJson["printed_name"] = "Phyrexian Contender"
+			cardsJson = append(cardsJson, phCardJson)
+			break
+		}
+	}
+	allCardsJson, err = json.Marshal(cardsJson)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataDir := writeDataDir(t, string(allCardsJson))
+
+	importer := mtgdb.NewImporter(dataDir)
+	importer.DownloadAssets = false
+	collection, _, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, card := range collection {
+		assert.Nil(t, card.Translation("en"))
+		switch card.SetCode + "-" + card.CollectorNumber {
+		case "eld-1":
+			assert.Equal(t, 11, len(card.Translations))
+			translation := card.Translation("es")
+			if assert.NotNil(t, translation) {
+				assert.Equal(t, card.EsName, translation.PrintedName)
+				assert.Equal(t, "Criatura — Caballero humano", translation.PrintedTypeLine)
+				assert.Equal(t, "d670142b-30d4-44fb-ba3a-997e69399e70", translation.ScryfallID)
+				assert.Equal(t, "https://cards.scryfall.io/normal/front/d/6/d670142b-30d4-44fb-ba3a-997e69399e70.jpg?1569990124", translation.ImageUrl)
+			}
+			translation = card.Translation("ph")
+			if assert.NotNil(t, translation) {
+				assert.Equal(t, "Phyrexian Contender", translation.PrintedName)
+				assert.Equal(t, "ph-eld-1", translation.ScryfallID)
+			}
+		case "isd-176":
+			assert.Equal(t, 10, len(card.Translations))
+			translation := card.Translation("de")
+			if assert.NotNil(t, translation) {
+				assert.Equal(t, "Morgengrauen-Waldläufer // Nachtbeginn-Jäger", translation.PrintedName)
+				assert.Equal(t, "Kreatur — Mensch, Bogenschütze, Werwolf // Kreatur — Werwolf", translation.PrintedTypeLine)
+			}
+		case "war-169★":
+			assert.Equal(t, 1, len(card.Translations))
+			assert.NotNil(t, card.Translation("ja"))
+		}
+	}
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -16,27 +21,58 @@ import (
 	"gorm.io/gorm/logger"
 )
 
-func createCustomItems(db *gorm.DB) error {
-	scope := db.Clauses(clause.OnConflict{UpdateAll: true}).Session(&gorm.Session{CreateBatchSize: 1000})
-
-	releasedAt, err := time.Parse(time.RFC3339, "1993-08-05T00:00:00.000Z")
+// createCustomItems upserts the custom sets and cards declared in the custom
+// items file of the data dir.
+func createCustomItems(db *gorm.DB, importer *mtgdb.Importer) error {
+	sets, cards, err := importer.LoadCustomItems()
 	if err != nil {
 		return err
 	}
-	set := mtgdb.Set{Name: "MTG Print Extra", Code: "extra", ParentCode: "extra", ReleasedAt: &releasedAt, Typology: "extra", IconName: "default"}
-	err = scope.Create(&set).Error
+	scope := db.Clauses(clause.OnConflict{UpdateAll: true}).Session(&gorm.Session{CreateBatchSize: 1000})
+	if len(sets) > 0 {
+		err = scope.Create(&sets).Error
+		if err != nil {
+			return err
+		}
+	}
+	if len(cards) > 0 {
+		return scope.Omit("Set").Create(&cards).Error
+	}
+	return nil
+}
+
+func writeReport(filePath string, report mtgdb.ImportReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
 	if err != nil {
 		return err
 	}
+	return ioutil.WriteFile(filePath, data, 0644)
+}
 
-	cards := []mtgdb.Card{
-		{EnName: "Back", SetCode: "extra", CollectorNumber: "001", Foil: false, NonFoil: true, HasBackSide: false, ReleasedAt: &releasedAt},
-		{EnName: "Back", SetCode: "extra", CollectorNumber: "002", Foil: false, NonFoil: true, HasBackSide: false, ReleasedAt: &releasedAt},
-		{EnName: "Back", SetCode: "extra", CollectorNumber: "003", Foil: false, NonFoil: true, HasBackSide: false, ReleasedAt: &releasedAt},
-		{EnName: "Back", SetCode: "extra", CollectorNumber: "004", Foil: false, NonFoil: true, HasBackSide: false, ReleasedAt: &releasedAt},
-		{EnName: "Back", SetCode: "extra", CollectorNumber: "005", Foil: false, NonFoil: true, HasBackSide: false, ReleasedAt: &releasedAt},
+func printDelta(delta mtgdb.DeltaReport, asJson bool) error {
+	if asJson {
+		data, err := json.MarshalIndent(delta, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
 	}
-	return scope.Omit("Set").Create(&cards).Error
+	fmt.Printf("New sets (%d): %s\n", len(delta.NewSets), strings.Join(delta.NewSets, ", "))
+	fmt.Printf("New cards (%d):\n", len(delta.Inserted))
+	for _, scryfallId := range delta.Inserted {
+		fmt.Printf("  %s\n", scryfallId)
+	}
+	fmt.Printf("Changed cards (%d):\n", len(delta.Updated))
+	for _, scryfallId := range delta.Updated {
+		fmt.Printf("  %s: %s\n", scryfallId, strings.Join(delta.ChangedFields[scryfallId], ", "))
+	}
+	fmt.Printf("Deleted cards (%d):\n", len(delta.Deleted))
+	for _, scryfallId := range delta.Deleted {
+		fmt.Printf("  %s\n", scryfallId)
+	}
+	fmt.Printf("Unchanged cards: %d\n", delta.Unchanged)
+	return nil
 }
 
 func init() {
@@ -47,53 +83,148 @@ func init() {
 }
 
 func main() {
-	var forceDownloadData, skipDownloadAssets, forceDownloadOlderAssets, forceDownloadDiffSha1, forceDownloadAssets, downloadOnlyEnAssets, displayProgressBar, help bool
-	var downloadConcurrency int
-	var setsString string
+	var forceDownloadData, dryRun, printJson, compressBulkData, skipDownloadAssets, forceDownloadOlderAssets, forceDownloadDiffSha1, forceDownloadAssets, downloadOnlyEnAssets, displayProgressBar, help bool
+	var downloadConcurrency, batchSize int
+	var maxDeletePercent float64
+	var setsString, reportFilePath, bulkDataType string
+	var setTypesString, excludeSetsString, releasedAfterString, releasedBeforeString string
+	var includeChildSets, onlyDigitalSets, onlyPaperSets bool
+	var gamesString, excludeLayoutsString string
+	var snapshot string
+	var archiveSnapshots, listSnapshots bool
+	var excludeDigitalCards, excludeOversizedCards, excludeNotEnCards bool
 	flag.BoolVar(&forceDownloadData, "u", false, "Update Scryfall database")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print what the import would change in the database, using the data already downloaded, without writing anything or downloading images")
+	flag.BoolVar(&printJson, "json", false, "Print the dry run changes as JSON")
+	flag.BoolVar(&compressBulkData, "compress", false, "Keep Scryfall bulk data files gzip compressed on disk")
 	flag.BoolVar(&skipDownloadAssets, "skip-assets", false, "Skip download of set and card images")
 	flag.BoolVar(&forceDownloadOlderAssets, "ftime", false, "Force re-download of card images, but only if the modified date is older")
 	// flag.BoolVar(&forceDownloadDiffSha1, "fsha1", false, "Force re-download of card images, but only if the sha1sum is changed")
 	flag.BoolVar(&forceDownloadAssets, "f", false, "Force re-download of card images")
 	flag.BoolVar(&downloadOnlyEnAssets, "en", true, "Download card images only in EN language")
 	flag.IntVar(&downloadConcurrency, "download-concurrency", 0, "Set max download concurrency")
+	flag.IntVar(&batchSize, "batch", 0, "Stream cards into the database in batches of this size instead of loading all cards in memory")
+	flag.Float64Var(&maxDeletePercent, "max-delete-percent", 10, "Abort the deletion of the cards removed from Scryfall if they are more than this percent of the cards of the imported sets")
+	flag.StringVar(&bulkDataType, "bulk", mtgdb.BulkDataAllCards, "Scryfall bulk data to import (all_cards, default_cards, oracle_cards or unique_artwork)")
 	flag.StringVar(&setsString, "only", "", "Import some sets (es: -only eld,war)")
+	flag.BoolVar(&includeChildSets, "children", true, "Import also the child sets, like tokens and promos, of the -only sets")
+	flag.StringVar(&setTypesString, "types", "", "Import only sets of these types (es: -types expansion,commander)")
+	flag.StringVar(&excludeSetsString, "exclude", "", "Do not import these sets (es: -exclude teld,peld)")
+	flag.StringVar(&releasedAfterString, "released-after", "", "Import only sets released on or after this date (es: -released-after 2019-01-01)")
+	flag.StringVar(&releasedBeforeString, "released-before", "", "Import only sets released on or before this date (es: -released-before 2019-12-31)")
+	flag.BoolVar(&onlyDigitalSets, "digital", false, "Import only digital sets")
+	flag.BoolVar(&onlyPaperSets, "paper", false, "Import only paper sets")
+	flag.StringVar(&gamesString, "games", "", "Import only cards available in these games (es: -games paper,mtgo)")
+	flag.StringVar(&excludeLayoutsString, "exclude-layouts", "", "Do not import cards with these layouts (es: -exclude-layouts art_series,token)")
+	flag.BoolVar(&excludeDigitalCards, "exclude-digital", false, "Do not import digital only cards")
+	flag.BoolVar(&excludeOversizedCards, "exclude-oversized", false, "Do not import oversized cards")
+	flag.BoolVar(&excludeNotEnCards, "exclude-not-en", false, "Do not import cards not printed in English")
 	flag.BoolVar(&displayProgressBar, "p", false, "Display progress bar")
+	flag.StringVar(&reportFilePath, "report", "", "Write the import report as JSON in this file")
+	flag.BoolVar(&archiveSnapshots, "archive", false, "Archive the downloaded Scryfall data in a new snapshot")
+	flag.StringVar(&snapshot, "snapshot", "", "Import the Scryfall data archived in this snapshot instead of the current one")
+	flag.BoolVar(&listSnapshots, "list-snapshots", false, "Print the archived snapshots")
 	flag.BoolVar(&help, "h", false, "Print this help")
 	flag.Parse()
 	if help {
 		flag.Usage()
 		os.Exit(0)
 	}
-	sets := strings.Split(setsString, ",")
-	// Append Token and Overside sets
-	for _, set := range sets {
-		sets = append(sets, fmt.Sprintf("t%s", set))
-		sets = append(sets, fmt.Sprintf("o%s", set))
-	}
 
 	log.Println("Importer initialization")
+	var err error
 	importer := mtgdb.NewImporter(os.Getenv("DATA_PATH"))
+	importer.BulkDataType = bulkDataType
 	importer.ForceDownloadData = forceDownloadData
-	importer.DownloadAssets = !skipDownloadAssets
+	importer.CompressBulkData = compressBulkData
+	importer.DownloadAssets = !skipDownloadAssets && !dryRun
 	importer.ForceDownloadOlderAssets = forceDownloadOlderAssets
 	importer.ForceDownloadDiffSha1 = forceDownloadDiffSha1
 	importer.ForceDownloadAssets = forceDownloadAssets
 	importer.DownloadOnlyEnAssets = downloadOnlyEnAssets
 	importer.DisplayProgressBar = displayProgressBar
-	if setsString != "" && len(sets) > 0 {
-		importer.OnlyTheseSetCodes = sets
+	importer.MaxDeletePercent = maxDeletePercent
+	importer.ArchiveSnapshots = archiveSnapshots
+	importer.Snapshot = snapshot
+	if setsString != "" {
+		importer.OnlyTheseSetCodes = strings.Split(setsString, ",")
+	}
+	importer.IncludeChildSets = includeChildSets
+	if setTypesString != "" {
+		importer.OnlyTheseSetTypes = strings.Split(setTypesString, ",")
+	}
+	if excludeSetsString != "" {
+		importer.ExcludeTheseSetCodes = strings.Split(excludeSetsString, ",")
+	}
+	if releasedAfterString != "" {
+		importer.SetsReleasedAfter, err = time.Parse("2006-01-02", releasedAfterString)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if releasedBeforeString != "" {
+		importer.SetsReleasedBefore, err = time.Parse("2006-01-02", releasedBeforeString)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	importer.OnlyDigitalSets = onlyDigitalSets
+	importer.OnlyPaperSets = onlyPaperSets
+	if gamesString != "" {
+		importer.CardFilters = append(importer.CardFilters, mtgdb.OnlyGames(strings.Split(gamesString, ",")...))
+	}
+	if excludeLayoutsString != "" {
+		importer.CardFilters = append(importer.CardFilters, mtgdb.ExcludeLayouts(strings.Split(excludeLayoutsString, ",")...))
+	}
+	if excludeDigitalCards {
+		importer.CardFilters = append(importer.CardFilters, mtgdb.ExcludeDigitalCards)
+	}
+	if excludeOversizedCards {
+		importer.CardFilters = append(importer.CardFilters, mtgdb.ExcludeOversizedCards)
 	}
+	importer.ExcludeNotEnCards = excludeNotEnCards
 	if downloadConcurrency > 0 {
 		importer.SetDownloadConcurrency(downloadConcurrency)
 	}
 
+	// Stop the import gracefully on SIGINT/SIGTERM
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Println("Interrupted, waiting for running downloads to stop")
+		cancel()
+		// A second signal kills the process
+		signal.Stop(signals)
+	}()
+
 	// Start
 
-	log.Println("Downloading data")
-	err := importer.DownloadData()
-	if err != nil {
-		panic(err)
+	if listSnapshots {
+		snapshots, err := importer.ListSnapshots()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, snapshot := range snapshots {
+			fmt.Println(snapshot)
+		}
+		return
+	}
+
+	switch {
+	case snapshot != "":
+		log.Printf("Using data of snapshot %s\n", snapshot)
+	case dryRun:
+		// A dry run does not change anything, not even the data files
+		log.Println("Using data already downloaded")
+	default:
+		log.Println("Downloading data")
+		err = importer.DownloadDataContext(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	log.Println("Open connection to database")
@@ -105,44 +236,138 @@ func main() {
 	if os.Getenv("DB_LOG") == "1" {
 		db.Config.Logger = db.Config.Logger.LogMode(logger.Info)
 	}
+	if dryRun {
+		log.Println("Comparing cards with database")
+		collection, _, err := importer.BuildCardsFromJsonContext(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cardsDelta, err := mtgdb.ComputeDelta(db, collection, nil, false)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var delta mtgdb.DeltaReport
+		delta.Add(cardsDelta)
+		collectionScryfallIds := make(map[string]struct{})
+		for _, card := range collection {
+			collectionScryfallIds[card.ScryfallID] = struct{}{}
+		}
+		missing, err := importer.MissingCards(db, collectionScryfallIds)
+		if err != nil {
+			log.Println(err)
+		}
+		for _, card := range missing {
+			delta.Deleted = append(delta.Deleted, card.ScryfallID)
+		}
+		err = printDelta(delta, printJson)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	log.Println("Database migration")
 	mtgdb.AutoMigrate(db)
 
-	err = createCustomItems(db)
+	err = createCustomItems(db, importer)
 	if err != nil {
 		panic(err)
 	}
 
 	log.Println("Filling database")
 	var beforeSetsCount, beforeCardsCount, afterSetsCount, afterCardsCount int64
-	var scryfallIds []string
 	db.Model(&mtgdb.Set{}).Count(&beforeSetsCount)
-	db.Model(&mtgdb.Card{}).Pluck("scryfall_id", &scryfallIds)
-	beforeCardsCount = int64(len(scryfallIds))
+	db.Model(&mtgdb.Card{}).Count(&beforeCardsCount)
 	start := time.Now()
-	collection, downloadedImagesCount := importer.BuildCardsFromJson()
-	err = mtgdb.BulkInsert(db, collection)
-	if err != nil {
-		log.Println(err)
+	var report mtgdb.ImportReport
+	var delta mtgdb.DeltaReport
+	processedCards := 0
+	processedOracleCards := 0
+	collectionScryfallIds := make(map[string]struct{})
+	// Write only the cards changed since the last import
+	writeCards := func(cards, updates []mtgdb.Card, oracleCards []mtgdb.OracleCard) error {
+		cardsDelta, err := mtgdb.ComputeDelta(db, cards, updates, batchSize > 0)
+		if err != nil {
+			return err
+		}
+		err = mtgdb.ApplyDelta(db, cardsDelta)
+		if err != nil {
+			return err
+		}
+		// Prices change every day: store them also for the unchanged cards
+		err = mtgdb.BulkInsertPrices(db, cards)
+		if err != nil {
+			return err
+		}
+		err = mtgdb.BulkInsertPrices(db, updates)
+		if err != nil {
+			return err
+		}
+		err = mtgdb.BulkInsertOracleCards(db, oracleCards)
+		if err != nil {
+			return err
+		}
+		delta.Add(cardsDelta)
+		processedOracleCards += len(oracleCards)
+		processedCards += len(cards)
+		for _, card := range cards {
+			collectionScryfallIds[card.ScryfallID] = struct{}{}
+		}
+		for _, card := range updates {
+			collectionScryfallIds[card.ScryfallID] = struct{}{}
+		}
+		return nil
+	}
+	if batchSize > 0 {
+		report, err = importer.StreamCardsFromJsonContext(ctx, batchSize, writeCards)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		var collection []mtgdb.Card
+		collection, report, err = importer.BuildCardsFromJsonContext(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = writeCards(collection, nil, importer.OracleCards())
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
-	log.Printf("Processed %d cards in %s\n", len(collection), time.Since(start))
+	log.Printf("Processed %d cards in %s\n", processedCards, time.Since(start))
 	db.Model(&mtgdb.Set{}).Count(&afterSetsCount)
 	db.Model(&mtgdb.Card{}).Count(&afterCardsCount)
-	log.Printf("Imported %d new sets and %d new cards (%d images updated)\n", afterSetsCount-beforeSetsCount, afterCardsCount-beforeCardsCount, downloadedImagesCount)
+	log.Printf("Imported %d new sets and %d new cards (%d images updated, %d failed)\n", afterSetsCount-beforeSetsCount, afterCardsCount-beforeCardsCount, report.ImagesDownloaded, len(report.FailedDownloads))
+	if len(report.StalePatches) > 0 {
+		log.Printf("Card patches not matching any card: %s\n", strings.Join(report.StalePatches, ", "))
+	}
+	log.Printf("Inserted %d cards, updated %d cards, %d cards unchanged\n", len(delta.Inserted), len(delta.Updated), delta.Unchanged)
 
-	// Remove deleted cards ONLY if no filter on sets
-	if setsString == "" {
-		collectionScryfallIds := make(map[string]struct{})
-		for _, card := range collection {
-			collectionScryfallIds[card.ScryfallID] = struct{}{}
-		}
-		scryfallIdsNotFound := make([]string, 0)
-		for _, scryfallId := range scryfallIds {
-			if _, found := collectionScryfallIds[scryfallId]; !found && scryfallId != "" {
-				scryfallIdsNotFound = append(scryfallIdsNotFound, scryfallId)
-			}
+	// The sets are written with their cards only when these change
+	err = mtgdb.BulkInsertSets(db, importer.Sets())
+	if err != nil {
+		log.Println(err)
+	}
+
+	err = mtgdb.ResolveCardRelations(db)
+	if err != nil {
+		log.Println(err)
+	}
+
+	log.Printf("Imported %d oracle cards\n", processedOracleCards)
+
+	// Remove the cards of the imported sets removed from Scryfall
+	delta.Deleted, err = importer.DeleteMissingCards(db, collectionScryfallIds)
+	if err != nil {
+		log.Println(err)
+	}
+	log.Printf("Deleted %d cards\n", len(delta.Deleted))
+
+	if reportFilePath != "" {
+		report.Delta = delta
+		err = writeReport(reportFilePath, report)
+		if err != nil {
+			log.Println(err)
 		}
-		db.Where("scryfall_id IN (?)", scryfallIdsNotFound).Delete(mtgdb.Card{})
-		log.Printf("Deleted %d cards\n", len(scryfallIdsNotFound))
 	}
 }
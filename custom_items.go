@@ -0,0 +1,210 @@
+package mtgdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CustomItemsFileNames are the files, inside the data dir, where custom sets
+// and cards, like card backs or playtest cards, can be declared. The first
+// file found is used.
+var CustomItemsFileNames = []string{"custom_items.json", "custom_items.yml", "custom_items.yaml"}
+
+type customItemsStruct struct {
+	Sets  []customSetStruct  `json:"sets" yaml:"sets"`
+	Cards []customCardStruct `json:"cards" yaml:"cards"`
+}
+
+type customSetStruct struct {
+	Name       string `json:"name" yaml:"name"`
+	Code       string `json:"code" yaml:"code"`
+	ParentCode string `json:"parent_code" yaml:"parent_code"`
+	ReleasedAt string `json:"released_at" yaml:"released_at"`
+	Typology   string `json:"typology" yaml:"typology"`
+	IconName   string `json:"icon_name" yaml:"icon_name"`
+	// Image file of the icon, relative to the data dir
+	IconImage string `json:"icon_image" yaml:"icon_image"`
+}
+
+type customCardStruct struct {
+	EnName          string  `json:"en_name" yaml:"en_name"`
+	SetCode         string  `json:"set_code" yaml:"set_code"`
+	CollectorNumber string  `json:"collector_number" yaml:"collector_number"`
+	Foil            bool    `json:"foil" yaml:"foil"`
+	NonFoil         bool    `json:"nonfoil" yaml:"nonfoil"`
+	ReleasedAt      string  `json:"released_at" yaml:"released_at"`
+	Artist          string  `json:"artist" yaml:"artist"`
+	CMC             float32 `json:"cmc" yaml:"cmc"`
+	Layout          string  `json:"layout" yaml:"layout"`
+	ManaCost        string  `json:"mana_cost" yaml:"mana_cost"`
+	OracleText      string  `json:"oracle_text" yaml:"oracle_text"`
+	Power           string  `json:"power" yaml:"power"`
+	Rarity          string  `json:"rarity" yaml:"rarity"`
+	Toughness       string  `json:"toughness" yaml:"toughness"`
+	TypeLine        string  `json:"type_line" yaml:"type_line"`
+	// Image files of the card, relative to the data dir
+	FrontImage string `json:"front_image" yaml:"front_image"`
+	BackImage  string `json:"back_image" yaml:"back_image"`
+}
+
+// defaultCustomItems are used when there is no custom items file: the set of
+// card backs always created by the previous versions.
+var defaultCustomItems = customItemsStruct{
+	Sets: []customSetStruct{
+		{Name: "MTG Print Extra", Code: "extra", ParentCode: "extra", ReleasedAt: "1993-08-05", Typology: "extra", IconName: "default"},
+	},
+	Cards: []customCardStruct{
+		{EnName: "Back", SetCode: "extra", CollectorNumber: "001", NonFoil: true, ReleasedAt: "1993-08-05"},
+		{EnName: "Back", SetCode: "extra", CollectorNumber: "002", NonFoil: true, ReleasedAt: "1993-08-05"},
+		{EnName: "Back", SetCode: "extra", CollectorNumber: "003", NonFoil: true, ReleasedAt: "1993-08-05"},
+		{EnName: "Back", SetCode: "extra", CollectorNumber: "004", NonFoil: true, ReleasedAt: "1993-08-05"},
+		{EnName: "Back", SetCode: "extra", CollectorNumber: "005", NonFoil: true, ReleasedAt: "1993-08-05"},
+	},
+}
+
+// LoadCustomItems loads the custom sets and cards declared in the custom items
+// file of importer.DataDir and copies their images, if any, into
+// importer.ImagesDir. The cards are validated with Card.IsValid and their Set
+// is set when the set is declared in the same file. Without a custom items
+// file the default items, the "MTG Print Extra" set with five card backs, are
+// returned: use an empty file to have none.
+func (importer *Importer) LoadCustomItems() ([]Set, []Card, error) {
+	items, err := importer.loadCustomItemsFile()
+	if err != nil {
+		return nil, nil, err
+	}
+	if items == nil {
+		items = &defaultCustomItems
+	}
+
+	sets := make([]Set, 0, len(items.Sets))
+	setsByCode := make(map[string]*Set)
+	for _, setJson := range items.Sets {
+		set := Set{
+			Name:       setJson.Name,
+			Code:       setJson.Code,
+			ParentCode: setJson.ParentCode,
+			ReleasedAt: parseTime("2006-01-02", setJson.ReleasedAt),
+			Typology:   setJson.Typology,
+			IconName:   setJson.IconName,
+		}
+		if set.Name == "" || set.Code == "" {
+			return nil, nil, fmt.Errorf("custom set `%s` is not valid", set.Code)
+		}
+		if set.ParentCode == "" {
+			set.ParentCode = set.Code
+		}
+		if set.IconName == "" {
+			set.IconName = set.Code
+		}
+		if setJson.IconImage != "" {
+			err = importer.copyCustomImage(setJson.IconImage, SetImagePath(importer.ImagesDir, set.IconName))
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		sets = append(sets, set)
+	}
+	for i := range sets {
+		setsByCode[sets[i].Code] = &sets[i]
+	}
+
+	cards := make([]Card, 0, len(items.Cards))
+	keys := make(map[string]struct{})
+	for _, cardJson := range items.Cards {
+		card := Card{
+			EnName:          cardJson.EnName,
+			SetCode:         cardJson.SetCode,
+			Set:             setsByCode[cardJson.SetCode],
+			CollectorNumber: cardJson.CollectorNumber,
+			Foil:            cardJson.Foil,
+			NonFoil:         cardJson.NonFoil,
+			HasBackSide:     cardJson.BackImage != "",
+			ReleasedAt:      parseTime("2006-01-02", cardJson.ReleasedAt),
+			Artist:          cardJson.Artist,
+			CMC:             cardJson.CMC,
+			Layout:          cardJson.Layout,
+			ManaCost:        cardJson.ManaCost,
+			OracleText:      cardJson.OracleText,
+			Power:           cardJson.Power,
+			Rarity:          cardJson.Rarity,
+			Toughness:       cardJson.Toughness,
+			TypeLine:        cardJson.TypeLine,
+		}
+		if card.ReleasedAt == nil && card.Set != nil {
+			card.ReleasedAt = card.Set.ReleasedAt
+		}
+		key := fmt.Sprintf("%s-%s", card.SetCode, card.CollectorNumber)
+		if !card.IsValid() {
+			return nil, nil, fmt.Errorf("custom card `%s` (set `%s`, collector number `%s`) is not valid", card.EnName, card.SetCode, card.CollectorNumber)
+		}
+		if _, found := keys[key]; found {
+			return nil, nil, fmt.Errorf("custom card with set `%s` and collector number `%s` is duplicated", card.SetCode, card.CollectorNumber)
+		}
+		keys[key] = struct{}{}
+		if cardJson.FrontImage != "" {
+			err = importer.copyCustomImage(cardJson.FrontImage, card.ImagePath(importer.ImagesDir, "en", false))
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		if cardJson.BackImage != "" {
+			err = importer.copyCustomImage(cardJson.BackImage, card.ImagePath(importer.ImagesDir, "en", true))
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		cards = append(cards, card)
+	}
+	return sets, cards, nil
+}
+
+func (importer *Importer) loadCustomItemsFile() (*customItemsStruct, error) {
+	for _, fileName := range CustomItemsFileNames {
+		filePath := filepath.Join(importer.DataDir, fileName)
+		data, err := ioutil.ReadFile(filePath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		items := &customItemsStruct{}
+		if len(bytes.TrimSpace(data)) == 0 {
+			// An empty file has no items
+			return items, nil
+		}
+		if strings.HasSuffix(fileName, ".json") {
+			err = json.Unmarshal(data, items)
+		} else {
+			err = yaml.Unmarshal(data, items)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("custom items file `%s` is not valid: %w", filePath, err)
+		}
+		return items, nil
+	}
+	return nil, nil
+}
+
+// copyCustomImage copies the image at imagePath, relative to the data dir,
+// into filePath.
+func (importer *Importer) copyCustomImage(imagePath, filePath string) error {
+	file, err := os.Open(filepath.Join(importer.DataDir, imagePath))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	err = createDirIfNotExist(filepath.Dir(filePath))
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(filePath, file, -1)
+}
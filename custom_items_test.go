@@ -0,0 +1,117 @@
+package mtgdb_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pioz/mtgdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeCustomItems(t *testing.T, fileName, content string) *mtgdb.Importer {
+	err := os.MkdirAll(filepath.Join(TEMP_DIR, "custom"), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(TEMP_DIR, fileName), []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(TEMP_DIR, "custom", "back.jpg"), []byte("back"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mtgdb.NewImporter(TEMP_DIR)
+}
+
+func TestImporterLoadCustomItems(t *testing.T) {
+	defer os.RemoveAll(TEMP_DIR)
+	importer := writeCustomItems(t, "custom_items.yml", `
+sets:
+  - name: MTG Print Extra
+    code: extra
+    released_at: "1993-08-05"
+    typology: extra
+    icon_name: default
+    icon_image: custom/back.jpg
+cards:
+  - en_name: Back
+    set_code: extra
+    collector_number: "001"
+    nonfoil: true
+    front_image: custom/back.jpg
+  - en_name: Back
+    set_code: extra
+    collector_number: "002"
+    nonfoil: true
+`)
+	sets, cards, err := importer.LoadCustomItems()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(sets))
+	assert.Equal(t, "extra", sets[0].ParentCode)
+	assert.Equal(t, 1993, sets[0].ReleasedAt.Year())
+	assert.Equal(t, 2, len(cards))
+	assert.Equal(t, "MTG Print Extra", cards[0].Set.Name)
+	assert.Equal(t, sets[0].ReleasedAt, cards[1].ReleasedAt)
+	assert.True(t, cards[0].NonFoil)
+	assert.False(t, cards[0].HasBackSide)
+
+	data, err := ioutil.ReadFile(cards[0].ImagePath(importer.ImagesDir, "en", false))
+	assert.Nil(t, err)
+	assert.Equal(t, "back", string(data))
+	_, err = os.Stat(sets[0].ImagePath(importer.ImagesDir))
+	assert.Nil(t, err)
+	_, err = os.Stat(cards[1].ImagePath(importer.ImagesDir, "en", false))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestImporterLoadCustomItemsDefault(t *testing.T) {
+	defer os.RemoveAll(TEMP_DIR)
+	importer := mtgdb.NewImporter(TEMP_DIR)
+	sets, cards, err := importer.LoadCustomItems()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equal(t, 1, len(sets)) {
+		assert.Equal(t, "MTG Print Extra", sets[0].Name)
+		assert.Equal(t, "extra", sets[0].Code)
+		assert.Equal(t, "default", sets[0].IconName)
+	}
+	assert.Equal(t, 5, len(cards))
+	for i, card := range cards {
+		assert.Equal(t, "Back", card.EnName)
+		assert.Equal(t, fmt.Sprintf("%03d", i+1), card.CollectorNumber)
+		assert.True(t, card.NonFoil)
+		assert.Equal(t, 1993, card.ReleasedAt.Year())
+	}
+
+	// An empty file has no items
+	for _, fileName := range []string{"custom_items.json", "custom_items.yml"} {
+		os.RemoveAll(TEMP_DIR)
+		importer = writeCustomItems(t, fileName, " \n")
+		sets, cards, err = importer.LoadCustomItems()
+		assert.Nil(t, err, fileName)
+		assert.Empty(t, sets, fileName)
+		assert.Empty(t, cards, fileName)
+	}
+}
+
+func TestImporterLoadCustomItemsInvalid(t *testing.T) {
+	defer os.RemoveAll(TEMP_DIR)
+	importer := writeCustomItems(t, "custom_items.json", `{"cards": [{"en_name": "Back", "set_code": "extra"}]}`)
+	_, _, err := importer.LoadCustomItems()
+	assert.EqualError(t, err, "custom card `Back` (set `extra`, collector number ``) is not valid")
+
+	importer = writeCustomItems(t, "custom_items.json", `{"cards": [{"en_name": "Back", "set_code": "extra", "collector_number": "1"}, {"en_name": "Back", "set_code": "extra", "collector_number": "1"}]}`)
+	_, _, err = importer.LoadCustomItems()
+	assert.EqualError(t, err, "custom card with set `extra` and collector number `1` is duplicated")
+
+	importer = writeCustomItems(t, "custom_items.json", `{"cards": [{"en_name": "Back", "set_code": "extra", "collector_number": "1", "front_image": "custom/missing.jpg"}]}`)
+	_, _, err = importer.LoadCustomItems()
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,434 @@
+package mtgdb
+
+import (
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CardsDelta is the difference between some built cards and the cards stored
+// in the database: only these cards have to be written.
+type CardsDelta struct {
+	// Codes of the new sets of the cards
+	NewSets []string
+	// New cards
+	Insert []Card
+	// Stored cards with some changed fields
+	Update []Card
+	// Partial updates, as passed to CardsBatchFunc, with some changed fields
+	Patch []Card
+	// Number of cards and partial updates already up to date
+	Unchanged int
+	// Changed fields of the updated cards by Scryfall ID
+	ChangedFields map[string][]string
+}
+
+// ComputeDelta compares cards and the partial updates in updates with the
+// cards stored in db with the same set code and collector number. If streamed
+// is true cards come from StreamCardsFromJson and can miss the names and
+// translations, or the English row, received later as updates: the missing
+// ones are taken from the stored cards and are not changes.
+func ComputeDelta(db *gorm.DB, cards, updates []Card, streamed bool) (CardsDelta, error) {
+	delta := CardsDelta{ChangedFields: make(map[string][]string)}
+	stored, err := loadStoredCards(db, cards, updates)
+	if err != nil {
+		return delta, err
+	}
+	delta.NewSets, err = newSets(db, cards)
+	if err != nil {
+		return delta, err
+	}
+	for _, card := range cards {
+		storedCard, found := stored[card.SetCode+"-"+card.CollectorNumber]
+		if !found {
+			delta.Insert = append(delta.Insert, card)
+			continue
+		}
+		if streamed {
+			mergeStoredLanguages(storedCard, &card)
+		}
+		fields := changedFields(storedCard, &card, false)
+		if len(fields) == 0 {
+			delta.Unchanged++
+			continue
+		}
+		delta.Update = append(delta.Update, card)
+		delta.ChangedFields[card.ScryfallID] = fields
+	}
+	for _, card := range updates {
+		storedCard, found := stored[card.SetCode+"-"+card.CollectorNumber]
+		if !found {
+			delta.Patch = append(delta.Patch, card)
+			continue
+		}
+		fields := changedFields(storedCard, &card, true)
+		if len(fields) == 0 {
+			delta.Unchanged++
+			continue
+		}
+		if card.ScryfallID == "" {
+			// Identify the patch in the report, the value is unchanged
+			card.ScryfallID = storedCard.ScryfallID
+		}
+		delta.Patch = append(delta.Patch, card)
+		delta.ChangedFields[card.ScryfallID] = fields
+	}
+	return delta, nil
+}
+
+// ApplyDelta writes delta into db.
+func ApplyDelta(db *gorm.DB, delta CardsDelta) error {
+	cards := make([]Card, 0, len(delta.Insert)+len(delta.Update))
+	cards = append(cards, delta.Insert...)
+	cards = append(cards, delta.Update...)
+	err := BulkInsert(db, cards)
+	if err != nil {
+		return err
+	}
+	return BulkUpdate(db, delta.Patch)
+}
+
+// newSets returns the codes of the sets of cards not stored in db.
+func newSets(db *gorm.DB, cards []Card) ([]string, error) {
+	setCodes := make([]string, 0)
+	seen := make(map[string]struct{})
+	for _, card := range cards {
+		if _, found := seen[card.SetCode]; !found && card.Set != nil {
+			seen[card.SetCode] = struct{}{}
+			setCodes = append(setCodes, card.SetCode)
+		}
+	}
+	if len(setCodes) == 0 {
+		return nil, nil
+	}
+	if !db.Migrator().HasTable(&Set{}) {
+		sort.Strings(setCodes)
+		return setCodes, nil
+	}
+	storedCodes := make([]string, 0)
+	err := db.Model(&Set{}).Where("code IN ?", setCodes).Pluck("code", &storedCodes).Error
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[string]struct{})
+	for _, code := range storedCodes {
+		stored[code] = struct{}{}
+	}
+	codes := make([]string, 0)
+	for _, code := range setCodes {
+		if _, found := stored[code]; !found {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	return codes, nil
+}
+
+// loadStoredCards returns the stored cards among cardsLists, with their
+// associations, by set code and collector number.
+func loadStoredCards(db *gorm.DB, cardsLists ...[]Card) (map[string]*Card, error) {
+	stored := make(map[string]*Card)
+	if !db.Migrator().HasTable(&Card{}) {
+		return stored, nil
+	}
+	storedCards := make([]Card, 0)
+	err := eachCardKeysChunk(func(keys [][]interface{}) error {
+		chunk := make([]Card, 0, len(keys))
+		err := db.Where("(set_code, collector_number) IN ?", keys).Find(&chunk).Error
+		storedCards = append(storedCards, chunk...)
+		return err
+	}, cardsLists...)
+	if err != nil {
+		return nil, err
+	}
+	byId := make(map[uint]*Card, len(storedCards))
+	ids := make([]uint, 0, len(storedCards))
+	for i := range storedCards {
+		stored[storedCards[i].SetCode+"-"+storedCards[i].CollectorNumber] = &storedCards[i]
+		byId[storedCards[i].ID] = &storedCards[i]
+		ids = append(ids, storedCards[i].ID)
+	}
+	// Associations are loaded in chunks: a preload binds all the card IDs in
+	// a single query. Their tables can be missing in a database not migrated
+	// yet, as in a dry run
+	if db.Migrator().HasTable(&CardFace{}) {
+		err = eachIdsChunk(ids, func(chunk []uint) error {
+			faces := make([]CardFace, 0)
+			err := db.Where("card_id IN ?", chunk).Order("card_id, ordinal").Find(&faces).Error
+			if err != nil {
+				return err
+			}
+			for _, face := range faces {
+				card := byId[face.CardID]
+				card.Faces = append(card.Faces, face)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if db.Migrator().HasTable(&CardRelation{}) {
+		err = eachIdsChunk(ids, func(chunk []uint) error {
+			relations := make([]CardRelation, 0)
+			err := db.Where("card_id IN ?", chunk).Order("card_id, id").Find(&relations).Error
+			if err != nil {
+				return err
+			}
+			for _, relation := range relations {
+				card := byId[relation.CardID]
+				card.Relations = append(card.Relations, relation)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if db.Migrator().HasTable(&CardTranslation{}) {
+		err = eachIdsChunk(ids, func(chunk []uint) error {
+			translations := make([]CardTranslation, 0)
+			err := db.Where("card_id IN ?", chunk).Order("card_id, lang").Find(&translations).Error
+			if err != nil {
+				return err
+			}
+			for _, translation := range translations {
+				card := byId[translation.CardID]
+				card.Translations = append(card.Translations, translation)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stored, nil
+}
+
+// localizedNameFields are the fields of Card set by the rows in languages
+// other than English.
+var localizedNameFields = []string{"EsName", "FrName", "DeName", "ItName", "PtName", "JaName", "KoName", "RuName", "ZhsName", "ZhtName"}
+
+// mergeStoredLanguages sets the names and translations of card, not set yet,
+// to the ones of the stored card. If card is built from a row not in English
+// also the fields of the English row are set to the stored ones.
+func mergeStoredLanguages(stored, card *Card) {
+	for _, translation := range card.Translations {
+		if translation.ScryfallID == card.ScryfallID {
+			card.ScryfallID = stored.ScryfallID
+			card.FrontImageUrl = stored.FrontImageUrl
+			card.BackImageUrl = stored.BackImageUrl
+			if len(card.Faces) == len(stored.Faces) {
+				card.Faces = stored.Faces
+			}
+			break
+		}
+	}
+	storedValue := reflect.ValueOf(stored).Elem()
+	cardValue := reflect.ValueOf(card).Elem()
+	for _, name := range localizedNameFields {
+		if cardValue.FieldByName(name).IsZero() {
+			cardValue.FieldByName(name).Set(storedValue.FieldByName(name))
+		}
+	}
+	// Copy the translations, card can share them with the batch
+	translations := card.Translations
+	card.Translations = make([]CardTranslation, len(translations))
+	copy(card.Translations, translations)
+	for _, translation := range stored.Translations {
+		if card.Translation(translation.Lang) == nil {
+			card.setTranslation(translation)
+		}
+	}
+}
+
+// changedFields returns the names of the fields of card that differ from the
+// stored card. If partial is true only the not zero fields of card are
+// compared. Fields are compared by the value written in the database, so that
+// for example an empty and a nil SliceString are the same.
+func changedFields(stored, card *Card, partial bool) []string {
+	fields := make([]string, 0)
+	storedValue := reflect.ValueOf(stored).Elem()
+	cardValue := reflect.ValueOf(card).Elem()
+	cardType := cardValue.Type()
+	for i := 0; i < cardType.NumField(); i++ {
+		name := cardType.Field(i).Name
+		if name == "ID" || name == "Set" || name == "Price" {
+			continue
+		}
+		field := cardValue.Field(i)
+		if partial && field.IsZero() {
+			continue
+		}
+		if name == "Translations" && partial {
+			// Partial updates have only the new translations
+			if !translationsIncluded(stored.Translations, card.Translations) {
+				fields = append(fields, name)
+			}
+			continue
+		}
+		if name == "Faces" || name == "Relations" || name == "Translations" {
+			if !associationsEqual(storedValue.Field(i), field) {
+				fields = append(fields, name)
+			}
+			continue
+		}
+		if !columnValueEqual(storedValue.Field(i).Interface(), field.Interface()) {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// associationsEqual compares two slices of faces or relations ignoring the
+// fields set when they are stored: ID, CardID and RelatedCardID.
+func associationsEqual(a, b reflect.Value) bool {
+	if a.Len() != b.Len() {
+		return false
+	}
+	itemType := a.Type().Elem()
+	for i := 0; i < a.Len(); i++ {
+		for j := 0; j < itemType.NumField(); j++ {
+			name := itemType.Field(j).Name
+			if name == "ID" || name == "CardID" || name == "RelatedCardID" {
+				continue
+			}
+			if !columnValueEqual(a.Index(i).Field(j).Interface(), b.Index(i).Field(j).Interface()) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// translationsIncluded returns true if all translations are already stored.
+func translationsIncluded(stored, translations []CardTranslation) bool {
+	storedByLang := make(map[string]CardTranslation, len(stored))
+	for _, translation := range stored {
+		storedByLang[translation.Lang] = translation
+	}
+	for _, translation := range translations {
+		storedTranslation, found := storedByLang[translation.Lang]
+		if !found || !associationsEqual(reflect.ValueOf([]CardTranslation{storedTranslation}), reflect.ValueOf([]CardTranslation{translation})) {
+			return false
+		}
+	}
+	return true
+}
+
+func columnValueEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case *time.Time:
+		b := b.(*time.Time)
+		if a == nil || b == nil {
+			return a == b
+		}
+		return a.Equal(*b)
+	case driver.Valuer:
+		aValue, aErr := a.Value()
+		bValue, bErr := b.(driver.Valuer).Value()
+		if aErr != nil || bErr != nil {
+			return false
+		}
+		if aBytes, ok := aValue.([]byte); ok {
+			aValue = string(aBytes)
+		}
+		if bBytes, ok := bValue.([]byte); ok {
+			bValue = string(bBytes)
+		}
+		return aValue == bValue
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// MissingCards returns the cards stored in db, in the sets of the last import,
+// whose Scryfall ID is not in scryfallIds: the cards removed from Scryfall. It
+// returns a TooManyDeletionsError if they are more than
+// importer.MaxDeletePercent percent of the stored cards of these sets.
+func (importer *Importer) MissingCards(db *gorm.DB, scryfallIds map[string]struct{}) ([]Card, error) {
+	missing := make([]Card, 0)
+	if len(importer.report.Sets) == 0 || !db.Migrator().HasTable(&Card{}) {
+		return missing, nil
+	}
+	stored := make([]Card, 0)
+	err := db.Select("id", "set_code", "collector_number", "scryfall_id").Where("set_code IN ? AND scryfall_id != ''", importer.report.Sets).Find(&stored).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, card := range stored {
+		if _, found := scryfallIds[card.ScryfallID]; !found {
+			missing = append(missing, card)
+		}
+	}
+	if len(missing) > 0 && float64(len(missing))*100 > importer.MaxDeletePercent*float64(len(stored)) {
+		return nil, &TooManyDeletionsError{Count: len(missing), Total: len(stored), MaxPercent: importer.MaxDeletePercent}
+	}
+	return missing, nil
+}
+
+// DeleteMissingCards deletes from db the cards returned by MissingCards and
+// their images. It returns the Scryfall IDs of the deleted cards.
+func (importer *Importer) DeleteMissingCards(db *gorm.DB, scryfallIds map[string]struct{}) ([]string, error) {
+	missing, err := importer.MissingCards(db, scryfallIds)
+	if err != nil || len(missing) == 0 {
+		return nil, err
+	}
+	ids := make([]uint, 0, len(missing))
+	deleted := make([]string, 0, len(missing))
+	for _, card := range missing {
+		ids = append(ids, card.ID)
+		deleted = append(deleted, card.ScryfallID)
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return eachIdsChunk(ids, func(chunk []uint) error {
+			for _, model := range []interface{}{&CardFace{}, &CardPrice{}, &CardRelation{}, &CardTranslation{}} {
+				err := tx.Where("card_id IN ?", chunk).Delete(model).Error
+				if err != nil {
+					return err
+				}
+			}
+			err := tx.Model(&CardRelation{}).Where("related_card_id IN ?", chunk).Update("related_card_id", nil).Error
+			if err != nil {
+				return err
+			}
+			return tx.Where("id IN ?", chunk).Delete(&Card{}).Error
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, card := range missing {
+		err = removeCardImages(importer.ImagesDir, card.SetCode, card.CollectorNumber)
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// removeCardImages removes the images in all languages of a card.
+func removeCardImages(imagesDir, setCode, collectorNumber string) error {
+	pattern := filepath.Join(CardImagesDir(imagesDir), globEscape(setCode), globEscape(setCode+"_"+collectorNumber)+"_*.jpg")
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		err = os.Remove(file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func globEscape(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`)
+	return replacer.Replace(s)
+}
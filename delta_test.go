@@ -0,0 +1,289 @@
+package mtgdb_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pioz/mtgdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangedFields(t *testing.T) {
+	releasedAt := time.Date(2019, 10, 4, 0, 0, 0, 0, time.UTC)
+	stored := mtgdb.Card{
+		ID:              42,
+		EnName:          "Gilded Goose",
+		SetCode:         "eld",
+		CollectorNumber: "160",
+		ReleasedAt:      &releasedAt,
+		Colors:          mtgdb.SliceString{"G"},
+		Keywords:        mtgdb.SliceString{},
+		Legalities:      mtgdb.MapString{"modern": "legal"},
+	}
+
+	// ID, Set, empty slices and times in other locations are not changes
+	sameReleasedAt := releasedAt.In(time.FixedZone("CEST", 2*60*60))
+	card := mtgdb.Card{
+		EnName:          "Gilded Goose",
+		SetCode:         "eld",
+		Set:             &mtgdb.Set{Code: "eld"},
+		CollectorNumber: "160",
+		ReleasedAt:      &sameReleasedAt,
+		Colors:          mtgdb.SliceString{"G"},
+		Legalities:      mtgdb.MapString{"modern": "legal"},
+	}
+	assert.Empty(t, mtgdb.ChangedFields(&stored, &card, false))
+
+	card.Legalities = mtgdb.MapString{"modern": "banned"}
+	card.Power = "1"
+	assert.Equal(t, []string{"Legalities", "Power"}, mtgdb.ChangedFields(&stored, &card, false))
+
+	// Partial updates compare only the not zero fields
+	update := mtgdb.Card{SetCode: "eld", CollectorNumber: "160", ItName: "Oca Dorata"}
+	assert.Equal(t, []string{"ItName"}, mtgdb.ChangedFields(&stored, &update, true))
+	stored.ItName = "Oca Dorata"
+	assert.Empty(t, mtgdb.ChangedFields(&stored, &update, true))
+	assert.NotEmpty(t, mtgdb.ChangedFields(&stored, &update, false))
+
+	// Faces are compared without their IDs
+	stored.Faces = []mtgdb.CardFace{{ID: 1, CardID: 42, Ordinal: 0, Name: "Front"}, {ID: 2, CardID: 42, Ordinal: 1, Name: "Back"}}
+	update = mtgdb.Card{SetCode: "eld", CollectorNumber: "160", Faces: []mtgdb.CardFace{{Ordinal: 0, Name: "Front"}, {Ordinal: 1, Name: "Back"}}}
+	assert.Empty(t, mtgdb.ChangedFields(&stored, &update, true))
+	update.Faces[1].Name = "Other back"
+	assert.Equal(t, []string{"Faces"}, mtgdb.ChangedFields(&stored, &update, true))
+
+	// Relations are compared without their resolved related card
+	relatedCardID := uint(7)
+	stored.Relations = []mtgdb.CardRelation{{ID: 1, CardID: 42, Component: "token", RelatedScryfallID: "wolf", RelatedCardID: &relatedCardID}}
+	update = mtgdb.Card{SetCode: "eld", CollectorNumber: "160", Relations: []mtgdb.CardRelation{{Component: "token", RelatedScryfallID: "wolf"}}}
+	assert.Empty(t, mtgdb.ChangedFields(&stored, &update, true))
+	update.Relations = append(update.Relations, mtgdb.CardRelation{Component: "token", RelatedScryfallID: "elf"})
+	assert.Equal(t, []string{"Relations"}, mtgdb.ChangedFields(&stored, &update, true))
+
+	// Partial updates compare only their translations
+	stored.Translations = []mtgdb.CardTranslation{{ID: 1, CardID: 42, Lang: "de", PrintedName: "Vergoldete Gans"}, {ID: 2, CardID: 42, Lang: "it", PrintedName: "Oca Dorata"}}
+	update = mtgdb.Card{SetCode: "eld", CollectorNumber: "160", Translations: []mtgdb.CardTranslation{{Lang: "it", PrintedName: "Oca Dorata"}}}
+	assert.Empty(t, mtgdb.ChangedFields(&stored, &update, true))
+	update.Translations[0].Lang = "ph"
+	assert.Equal(t, []string{"Translations"}, mtgdb.ChangedFields(&stored, &update, true))
+}
+
+func TestChangedFieldsOfStreamedCards(t *testing.T) {
+	importer := mtgdb.NewImporter(filepath.Join(FIXTURES_PATH, "data"))
+	importer.DownloadAssets = false
+	collection, _, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := make(map[string]*mtgdb.Card)
+	for i := range collection {
+		stored[collection[i].SetCode+"-"+collection[i].CollectorNumber] = &collection[i]
+	}
+
+	// Streaming the stored cards changes nothing, also when the other
+	// languages of a card arrive after it is flushed
+	incompleteCount, updatesCount := 0, 0
+	_, err = importer.StreamCardsFromJson(3, func(cards, updates []mtgdb.Card, oracleCards []mtgdb.OracleCard) error {
+		updatesCount += len(updates)
+		for _, card := range cards {
+			storedCard := stored[card.SetCode+"-"+card.CollectorNumber]
+			if len(mtgdb.ChangedFields(storedCard, &card, false)) > 0 {
+				incompleteCount++
+			}
+			mtgdb.MergeStoredLanguages(storedCard, &card)
+			assert.Empty(t, mtgdb.ChangedFields(storedCard, &card, false), card.SetCode+"-"+card.CollectorNumber)
+		}
+		for _, card := range updates {
+			storedCard := stored[card.SetCode+"-"+card.CollectorNumber]
+			assert.Empty(t, mtgdb.ChangedFields(storedCard, &card, true), card.SetCode+"-"+card.CollectorNumber)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotZero(t, incompleteCount)
+	assert.NotZero(t, updatesCount)
+}
+
+func TestComputeDeltaStreamedOnPopulatedDB(t *testing.T) {
+	db := openTestDB(t)
+	importer := mtgdb.NewImporter(filepath.Join(FIXTURES_PATH, "data"))
+	importer.DownloadAssets = false
+	collection, _, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	delta, err := mtgdb.ComputeDelta(db, collection, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(collection), len(delta.Insert))
+	err = mtgdb.ApplyDelta(db, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var report mtgdb.DeltaReport
+	_, err = importer.StreamCardsFromJson(3, func(cards, updates []mtgdb.Card, oracleCards []mtgdb.OracleCard) error {
+		delta, err := mtgdb.ComputeDelta(db, cards, updates, true)
+		if err != nil {
+			return err
+		}
+		report.Add(delta)
+		return mtgdb.ApplyDelta(db, delta)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, report.NewSets)
+	assert.Empty(t, report.Inserted)
+	assert.Empty(t, report.Updated)
+	assert.Empty(t, report.ChangedFields)
+	assert.NotZero(t, report.Unchanged)
+}
+
+func TestDeltaReportAdd(t *testing.T) {
+	report := mtgdb.DeltaReport{}
+	report.Add(mtgdb.CardsDelta{
+		Insert:    []mtgdb.Card{{ScryfallID: "a"}},
+		Update:    []mtgdb.Card{{ScryfallID: "b"}},
+		Patch:     []mtgdb.Card{{ScryfallID: "c"}, {}},
+		Unchanged: 3,
+	})
+	report.Add(mtgdb.CardsDelta{Insert: []mtgdb.Card{{ScryfallID: "d"}}, Unchanged: 1})
+	assert.Equal(t, []string{"a", "d"}, report.Inserted)
+	assert.Equal(t, []string{"b", "c"}, report.Updated)
+	assert.Equal(t, 4, report.Unchanged)
+}
+
+func TestRemoveCardImages(t *testing.T) {
+	defer os.RemoveAll(TEMP_DIR)
+	imagesDir := filepath.Join(TEMP_DIR, "images")
+	err := os.MkdirAll(filepath.Join(mtgdb.CardImagesDir(imagesDir), "eld"), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []string{
+		mtgdb.CardImagePath(imagesDir, "eld", "1", "en", false),
+		mtgdb.CardImagePath(imagesDir, "eld", "1", "it", true),
+		mtgdb.CardImagePath(imagesDir, "eld", "10", "en", false),
+		mtgdb.CardImagePath(imagesDir, "eld", "1*", "en", false),
+	}
+	for _, file := range files {
+		err = ioutil.WriteFile(file, []byte("image"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = mtgdb.RemoveCardImages(imagesDir, "eld", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, file := range files {
+		_, err = os.Stat(file)
+		assert.Equal(t, i < 2, os.IsNotExist(err), file)
+	}
+}
+
+func TestTooManyDeletionsError(t *testing.T) {
+	err := &mtgdb.TooManyDeletionsError{Count: 30, Total: 100, MaxPercent: 10}
+	assert.Equal(t, "refusing to delete 30 cards out of 100, more than 10%", err.Error())
+}
+
+func TestDBDeleteMissingCards(t *testing.T) {
+	defer os.RemoveAll(TEMP_DIR)
+	db := openTestDB(t)
+	importer := mtgdb.NewImporter(filepath.Join(FIXTURES_PATH, "data"))
+	importer.DownloadAssets = false
+	collection, _, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mtgdb.BulkInsert(db, collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mtgdb.ResolveCardRelations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contender, emblem mtgdb.Card
+	db.Where("set_code = ? AND collector_number = ?", "eld", "1").First(&contender)
+	db.Where("set_code = ? AND collector_number = ?", "teld", "19").First(&emblem)
+	var translationsCount int64
+	db.Model(&mtgdb.CardTranslation{}).Where("card_id = ?", contender.ID).Count(&translationsCount)
+	assert.NotZero(t, translationsCount)
+
+	imagesDir := filepath.Join(TEMP_DIR, "images")
+	files := []string{
+		mtgdb.CardImagePath(imagesDir, "eld", "1", "en", false),
+		mtgdb.CardImagePath(imagesDir, "eld", "1", "es", false),
+		mtgdb.CardImagePath(imagesDir, "isd", "176", "en", false),
+	}
+	for _, file := range files {
+		err = os.MkdirAll(filepath.Dir(file), os.ModePerm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(file, []byte("image"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Import again only eld and teld, where eld-1 and teld-19 are gone
+	importer = mtgdb.NewImporter(filepath.Join(FIXTURES_PATH, "data"))
+	importer.DownloadAssets = false
+	importer.ImagesDir = imagesDir
+	importer.OnlyTheseSetCodes = []string{"eld", "teld"}
+	collection, report, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"eld", "teld"}, report.Sets)
+	scryfallIds := make(map[string]struct{})
+	for _, card := range collection {
+		if card.ScryfallID != contender.ScryfallID && card.ScryfallID != emblem.ScryfallID {
+			scryfallIds[card.ScryfallID] = struct{}{}
+		}
+	}
+
+	// 2 cards out of 4 are too many
+	_, err = importer.DeleteMissingCards(db, scryfallIds)
+	assert.Equal(t, &mtgdb.TooManyDeletionsError{Count: 2, Total: 4, MaxPercent: 10}, err)
+	var cardsCount int64
+	db.Model(&mtgdb.Card{}).Count(&cardsCount)
+	assert.Equal(t, int64(10), cardsCount)
+
+	importer.MaxDeletePercent = 50
+	deleted, err := importer.DeleteMissingCards(db, scryfallIds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.ElementsMatch(t, []string{contender.ScryfallID, emblem.ScryfallID}, deleted)
+
+	// The cards of the sets not imported, like isd, are kept
+	db.Model(&mtgdb.Card{}).Count(&cardsCount)
+	assert.Equal(t, int64(8), cardsCount)
+	db.Model(&mtgdb.Card{}).Where("set_code = ?", "isd").Count(&cardsCount)
+	assert.Equal(t, int64(1), cardsCount)
+
+	db.Model(&mtgdb.CardTranslation{}).Where("card_id = ?", contender.ID).Count(&translationsCount)
+	assert.Zero(t, translationsCount)
+	var relation mtgdb.CardRelation
+	err = db.Where("related_scryfall_id = ?", emblem.ScryfallID).First(&relation).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, relation.RelatedCardID)
+
+	for i, file := range files {
+		_, err = os.Stat(file)
+		assert.Equal(t, i < 2, os.IsNotExist(err), file)
+	}
+}
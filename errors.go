@@ -0,0 +1,75 @@
+package mtgdb
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrInvalidCard is returned, wrapped in an InvalidCardError, when a card
+// built from the Scryfall data is not valid.
+var ErrInvalidCard = errors.New("card is not valid")
+
+type InvalidCardError struct {
+	ScryfallID      string
+	SetCode         string
+	CollectorNumber string
+}
+
+func (err *InvalidCardError) Error() string {
+	return fmt.Sprintf("card %s (set `%s`, collector number `%s`) is not valid", err.ScryfallID, err.SetCode, err.CollectorNumber)
+}
+
+func (err *InvalidCardError) Unwrap() error {
+	return ErrInvalidCard
+}
+
+// DownloadError is the error of a failed download of the file at Url into
+// FilePath.
+type DownloadError struct {
+	Url      string
+	FilePath string
+	Err      error
+}
+
+func (err *DownloadError) Error() string {
+	return fmt.Sprintf("download of `%s` into `%s` failed: %s", err.Url, err.FilePath, err.Err)
+}
+
+func (err *DownloadError) Unwrap() error {
+	return err.Err
+}
+
+// HttpError is the error of a request completed with an unexpected status
+// code.
+type HttpError struct {
+	Url        string
+	StatusCode int
+	// RetryAfter is the time to wait before retrying the request as requested
+	// by the server with the Retry-After header.
+	RetryAfter time.Duration
+}
+
+func (err *HttpError) Error() string {
+	return fmt.Sprintf("download file `%s` failed with status code %d", err.Url, err.StatusCode)
+}
+
+// Temporary returns true if retrying the request could succeed: on too many
+// requests, on request timeout and on server errors.
+func (err *HttpError) Temporary() bool {
+	return err.StatusCode == http.StatusTooManyRequests || err.StatusCode == http.StatusRequestTimeout || err.StatusCode >= 500
+}
+
+// TooManyDeletionsError is returned when the cards to delete, Count out of
+// Total, are more than MaxPercent percent. Usually it means that the Scryfall
+// data is incomplete.
+type TooManyDeletionsError struct {
+	Count      int
+	Total      int
+	MaxPercent float64
+}
+
+func (err *TooManyDeletionsError) Error() string {
+	return fmt.Sprintf("refusing to delete %d cards out of %d, more than %g%%", err.Count, err.Total, err.MaxPercent)
+}
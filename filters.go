@@ -0,0 +1,44 @@
+package mtgdb
+
+// CardFilter reports whether card has to be imported.
+type CardFilter func(card *Card) bool
+
+// ExcludeDigitalCards filters out the cards printed only for digital games.
+func ExcludeDigitalCards(card *Card) bool {
+	return !card.Digital
+}
+
+// ExcludeOversizedCards filters out the oversized cards.
+func ExcludeOversizedCards(card *Card) bool {
+	return !card.Oversized
+}
+
+// OnlyGames returns a filter that imports only the cards available in at
+// least one of games (paper, arena, mtgo).
+func OnlyGames(games ...string) CardFilter {
+	return func(card *Card) bool {
+		for _, game := range card.Games {
+			if contains(games, game) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ExcludeLayouts returns a filter that filters out the cards with one of
+// layouts (art_series, token, emblem...) on the front or on the back.
+func ExcludeLayouts(layouts ...string) CardFilter {
+	return func(card *Card) bool {
+		return !contains(layouts, card.Layout) && !contains(layouts, card.LayoutBack)
+	}
+}
+
+func (importer *Importer) isCardFiltered(card *Card) bool {
+	for _, filter := range importer.CardFilters {
+		if !filter(card) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package mtgdb_test
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/pioz/mtgdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImporterCardFilters(t *testing.T) {
+	importer := mtgdb.NewImporter(filepath.Join(FIXTURES_PATH, "data"))
+	importer.DownloadAssets = false
+	importer.CardFilters = []mtgdb.CardFilter{mtgdb.OnlyGames("arena"), mtgdb.ExcludeDigitalCards, mtgdb.ExcludeOversizedCards}
+	collection, report, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes := make([]string, 0)
+	for _, card := range collection {
+		codes = append(codes, card.SetCode+"-"+card.CollectorNumber)
+	}
+	sort.Strings(codes)
+	assert.Equal(t, []string{"eld-1", "eld-191", "war-169★"}, codes)
+	assert.Equal(t, 7, report.FilteredCards)
+	assert.Equal(t, map[string]int{"eld": 2, "war": 1}, report.CardsPerSet)
+
+	importer.CardFilters = []mtgdb.CardFilter{mtgdb.ExcludeLayouts("emblem", "transform")}
+	collection, _, err = importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 8, len(collection))
+
+	// Custom filter
+	importer.CardFilters = []mtgdb.CardFilter{func(card *mtgdb.Card) bool {
+		return card.SetCode != "eld"
+	}}
+	collection, _, err = importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 7, len(collection))
+}
+
+func TestImporterExcludeNotEnCards(t *testing.T) {
+	importer := mtgdb.NewImporter(filepath.Join(FIXTURES_PATH, "data"))
+	importer.DownloadAssets = false
+	importer.ExcludeNotEnCards = true
+	collection, report, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 9, len(collection))
+	assert.Equal(t, 1, report.FilteredCards)
+	for _, card := range collection {
+		assert.NotEqual(t, "war", card.SetCode)
+	}
+
+	// Streaming keeps the cards without an English printing until the end
+	streamed := 0
+	report, err = importer.StreamCardsFromJson(1, func(cards, updates []mtgdb.Card, oracleCards []mtgdb.OracleCard) error {
+		for _, card := range cards {
+			assert.NotEqual(t, "war", card.SetCode)
+		}
+		streamed += len(cards)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 9, streamed)
+	assert.Equal(t, 1, report.FilteredCards)
+	assert.Zero(t, report.CardsPerSet["war"])
+}
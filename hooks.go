@@ -0,0 +1,59 @@
+package mtgdb
+
+// ScryfallSet is a set as found in the Scryfall data.
+type ScryfallSet = setJsonStruct
+
+// ScryfallCard is a card printing as found in the Scryfall data.
+type ScryfallCard = cardJsonStruct
+
+// ImportHook lets library users enrich or correct the sets and cards built
+// from the Scryfall data before they are returned. An error stops the import.
+type ImportHook interface {
+	// AfterBuildSet is called once for each imported set.
+	AfterBuildSet(setJson *ScryfallSet, set *Set) error
+	// AfterBuildCard is called for each printing, in any language, of the
+	// imported cards. When streaming, card can be a partial update of a card
+	// already flushed (see CardsBatchFunc).
+	AfterBuildCard(cardJson *ScryfallCard, card *Card) error
+}
+
+// ImportHookFuncs is an ImportHook made of functions. Nil functions are
+// skipped.
+type ImportHookFuncs struct {
+	Set  func(setJson *ScryfallSet, set *Set) error
+	Card func(cardJson *ScryfallCard, card *Card) error
+}
+
+func (hook ImportHookFuncs) AfterBuildSet(setJson *ScryfallSet, set *Set) error {
+	if hook.Set == nil {
+		return nil
+	}
+	return hook.Set(setJson, set)
+}
+
+func (hook ImportHookFuncs) AfterBuildCard(cardJson *ScryfallCard, card *Card) error {
+	if hook.Card == nil {
+		return nil
+	}
+	return hook.Card(cardJson, card)
+}
+
+func (importer *Importer) runSetHooks(setJson *setJsonStruct, set *Set) error {
+	for _, hook := range importer.Hooks {
+		err := hook.AfterBuildSet(setJson, set)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (importer *Importer) runCardHooks(cardJson *cardJsonStruct, card *Card) error {
+	for _, hook := range importer.Hooks {
+		err := hook.AfterBuildCard(cardJson, card)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package mtgdb_test
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pioz/mtgdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImporterHooks(t *testing.T) {
+	importer := mtgdb.NewImporter(filepath.Join(FIXTURES_PATH, "data"))
+	importer.DownloadAssets = false
+	importer.OnlyTheseSetCodes = []string{"isd"}
+	languages := make([]string, 0)
+	importer.Hooks = []mtgdb.ImportHook{mtgdb.ImportHookFuncs{
+		Set: func(setJson *mtgdb.ScryfallSet, set *mtgdb.Set) error {
+			set.Name = strings.ToUpper(setJson.Name)
+			return nil
+		},
+		Card: func(cardJson *mtgdb.ScryfallCard, card *mtgdb.Card) error {
+			languages = append(languages, cardJson.Lang)
+			card.FlavorName = "Tagged " + cardJson.SetCode
+			return nil
+		},
+	}}
+	collection, _, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(collection))
+	assert.Equal(t, "INNISTRAD", collection[0].Set.Name)
+	assert.Equal(t, "Tagged isd", collection[0].FlavorName)
+	assert.Equal(t, 11, len(languages))
+
+	hookErr := errors.New("hook failed")
+	importer.Hooks = []mtgdb.ImportHook{mtgdb.ImportHookFuncs{
+		Card: func(cardJson *mtgdb.ScryfallCard, card *mtgdb.Card) error {
+			return hookErr
+		},
+	}}
+	_, _, err = importer.BuildCardsFromJson()
+	assert.Equal(t, hookErr, err)
+}
@@ -2,16 +2,20 @@ package mtgdb
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -23,10 +27,28 @@ import (
 	"gorm.io/gorm/clause"
 )
 
+// Scryfall bulk data types that can be used as cards source, see
+// https://scryfall.com/docs/api/bulk-data
+const (
+	BulkDataAllCards      = "all_cards"
+	BulkDataDefaultCards  = "default_cards"
+	BulkDataOracleCards   = "oracle_cards"
+	BulkDataUniqueArtwork = "unique_artwork"
+)
+
 type Importer struct {
 	DataDir                  string
+	BulkDataType             string
+	CompressBulkData         bool
 	ImagesDir                string
 	OnlyTheseSetCodes        []string
+	IncludeChildSets         bool
+	OnlyTheseSetTypes        []string
+	ExcludeTheseSetCodes     []string
+	SetsReleasedAfter        time.Time
+	SetsReleasedBefore       time.Time
+	OnlyDigitalSets          bool
+	OnlyPaperSets            bool
 	ForceDownloadData        bool
 	DownloadAssets           bool
 	DownloadOnlyEnAssets     bool
@@ -35,24 +57,57 @@ type Importer struct {
 	ForceDownloadAssets      bool
 	ImageType                string
 	DisplayProgressBar       bool
+	SkipInvalidCards         bool
+	CardFilters              []CardFilter
+	ExcludeNotEnCards        bool
+	Hooks                    []ImportHook
+	MaxDeletePercent         float64
+	ScryfallApiUrl           string
+	HttpClient               *http.Client
+	RetryAttempts            int
+	RetryDelay               time.Duration
+	ArchiveSnapshots         bool
+	Snapshot                 string
 
 	cardCollection        map[string]*Card
+	flushedCards          map[string]struct{}
+	filteredCards         map[string]struct{}
+	notEnCards            map[string]struct{}
+	cardPatches           *cardPatches
+	cardUpdates           map[string]*Card
+	batchSize             int
+	flushCards            CardsBatchFunc
 	setCollection         map[string]*Set
+	selectedSets          map[string]struct{}
 	rulingsCollection     map[string]Rulings
+	pricesDate            time.Time
+	oraclePrintings       map[string]oraclePrinting
 	setIconsDownloaded    map[string]struct{}
-	notEnImagesToDownload map[string]*cardJsonStruct
-	downloadedImagesCount uint32
+	notEnImagesToDownload map[string]downloadJob
+	report                ImportReport
+	reportMutex           sync.Mutex
 
+	ctx                 context.Context
 	errorsChan          chan error
+	errorsCollected     chan struct{}
 	wg                  sync.WaitGroup
-	downloaderSemaphore chan struct{}
+	downloadConcurrency int
+	downloadJobs        chan downloadJob
+	rateLimiters        map[string]*rateLimiter
 	bar                 *pb.ProgressBar
 }
 
 func NewImporter(dataDir string) *Importer {
 	return &Importer{
 		DataDir:                  dataDir,
+		BulkDataType:             BulkDataAllCards,
+		CompressBulkData:         false,
 		OnlyTheseSetCodes:        []string{},
+		IncludeChildSets:         false,
+		OnlyTheseSetTypes:        []string{},
+		ExcludeTheseSetCodes:     []string{},
+		OnlyDigitalSets:          false,
+		OnlyPaperSets:            false,
 		ImagesDir:                filepath.Join(dataDir, "images"),
 		ForceDownloadData:        false,
 		DownloadAssets:           true,
@@ -62,124 +117,269 @@ func NewImporter(dataDir string) *Importer {
 		ForceDownloadAssets:      false,
 		ImageType:                "normal",
 		DisplayProgressBar:       false,
-		errorsChan:               make(chan error, 10),
-		downloaderSemaphore:      make(chan struct{}, 50),
+		SkipInvalidCards:         false,
+		CardFilters:              []CardFilter{},
+		ExcludeNotEnCards:        false,
+		Hooks:                    []ImportHook{},
+		MaxDeletePercent:         10,
+		ScryfallApiUrl:           "https://api.scryfall.com",
+		HttpClient:               http.DefaultClient,
+		RetryAttempts:            3,
+		RetryDelay:               100 * time.Millisecond,
+		ArchiveSnapshots:         false,
+		Snapshot:                 "",
+		downloadConcurrency:      50,
+		rateLimiters: map[string]*rateLimiter{
+			"api.scryfall.com": newRateLimiter(100*time.Millisecond, 1),
+		},
 	}
 }
 
+// SetDownloadConcurrency sets the number of workers that download the images.
 func (importer *Importer) SetDownloadConcurrency(n int) {
-	importer.downloaderSemaphore = make(chan struct{}, n)
+	importer.downloadConcurrency = n
+}
+
+// SetRateLimit limits the requests to host to one every interval, with bursts
+// of at most burst requests. An interval of 0 removes the limit. By default
+// the requests to api.scryfall.com are limited to one every 100ms as asked by
+// Scryfall.
+func (importer *Importer) SetRateLimit(host string, interval time.Duration, burst int) {
+	if interval <= 0 {
+		delete(importer.rateLimiters, host)
+		return
+	}
+	importer.rateLimiters[host] = newRateLimiter(interval, burst)
 }
 
 func (importer *Importer) DownloadData() error {
-	createDirIfNotExist(importer.DataDir)
+	return importer.DownloadDataContext(context.Background())
+}
 
-	allSetsJsonFilePath := filepath.Join(importer.DataDir, "all_sets.json")
-	allCardsJsonFilePath := filepath.Join(importer.DataDir, "all_cards.json")
-	rulingsJsonFilePath := filepath.Join(importer.DataDir, "rulings.json")
-	if _, err := os.Stat(allSetsJsonFilePath); importer.ForceDownloadData || os.IsNotExist(err) {
-		err := downloadFile(allSetsJsonFilePath, "https://api.scryfall.com/sets")
-		if err != nil {
-			return err
-		}
+// DownloadDataContext is like DownloadData but stops as soon as ctx is done,
+// removing the partially written files and returning ctx.Err().
+func (importer *Importer) DownloadDataContext(ctx context.Context) error {
+	downloaded, err := importer.downloadDataFiles(ctx)
+	if err != nil {
+		return err
 	}
-	if _, err := os.Stat(allCardsJsonFilePath); importer.ForceDownloadData || os.IsNotExist(err) {
-		urls, err := fetchAllCardsDataUrl()
-		if err != nil {
-			return err
-		}
-		err = downloadFile(allCardsJsonFilePath, urls["all_cards"])
-		if err != nil {
-			return err
-		}
-		err = downloadFile(rulingsJsonFilePath, urls["rulings"])
+	if importer.ArchiveSnapshots && downloaded {
+		snapshot, err := importer.archiveSnapshot()
 		if err != nil {
 			return err
 		}
+		log.Printf("Data archived in snapshot `%s`\n", snapshot)
 	}
 	return nil
 }
 
-func (importer *Importer) BuildCardsFromJson() ([]Card, uint32) {
-	defer removeAllFilesByExtension(SetImagesDir(importer.ImagesDir), "svg")
+// downloadDataFiles downloads the data files and reports whether some file
+// has been downloaded.
+func (importer *Importer) downloadDataFiles(ctx context.Context) (downloaded bool, err error) {
+	err = createDirIfNotExist(importer.DataDir)
+	if err != nil {
+		return false, err
+	}
 
-	importer.downloadedImagesCount = 0
-	importer.cardCollection = make(map[string]*Card)
-	importer.setCollection = make(map[string]*Set)
-	importer.rulingsCollection = make(map[string]Rulings)
-	if importer.DownloadAssets {
-		createDirIfNotExist(SetImagesDir(importer.ImagesDir))
-		importer.setIconsDownloaded = make(map[string]struct{})
-		importer.notEnImagesToDownload = make(map[string]*cardJsonStruct)
+	allSetsJsonFilePath := filepath.Join(importer.DataDir, "all_sets.json")
+	if _, err := os.Stat(allSetsJsonFilePath); importer.ForceDownloadData || os.IsNotExist(err) {
+		err := importer.downloadFile(ctx, allSetsJsonFilePath, importer.ScryfallApiUrl+"/sets")
+		if err != nil {
+			return false, err
+		}
+		downloaded = true
 	}
 
-	// Fill importer.rulingsCollection
-	rulingsJson := make([]rulingsJsonStruct, 0)
-	err := loadFile(filepath.Join(importer.DataDir, "rulings.json"), &rulingsJson)
-	if err != nil {
-		panic(err)
+	bulkDataFilePaths := map[string]string{
+		importer.BulkDataType: importer.bulkDataFilePath(importer.BulkDataType),
+		"rulings":             importer.bulkDataFilePath("rulings"),
 	}
-	for _, rulingJson := range rulingsJson {
-		importer.buildRuling(&rulingJson)
+	missing := false
+	for _, filePath := range bulkDataFilePaths {
+		if _, err := os.Stat(filePath); os.IsNotExist(err) {
+			missing = true
+		}
+	}
+	if !importer.ForceDownloadData && !missing {
+		return downloaded, nil
 	}
 
-	// Fill importer.setCollection
-	setsJson := setsJsonStruct{}
-	err = loadFile(filepath.Join(importer.DataDir, "all_sets.json"), &setsJson)
+	remoteBulkData, err := importer.fetchBulkData(ctx)
 	if err != nil {
-		panic(err)
+		return downloaded, err
 	}
-	for _, setJson := range setsJson.Data {
-		if len(importer.OnlyTheseSetCodes) != 0 && !contains(importer.OnlyTheseSetCodes, setJson.Code) {
+	localBulkData, err := loadBulkDataMetadata(importer.DataDir)
+	if err != nil {
+		return downloaded, err
+	}
+	for _, bulkDataType := range []string{importer.BulkDataType, "rulings"} {
+		remote, found := remoteBulkData[bulkDataType]
+		if !found {
+			return downloaded, fmt.Errorf("bulk data of type `%s` not found", bulkDataType)
+		}
+		filePath := bulkDataFilePaths[bulkDataType]
+		if isBulkDataFileUpToDate(filePath, localBulkData[bulkDataType], remote) {
+			log.Printf("Bulk data `%s` is up to date (updated at %s)\n", bulkDataType, remote.UpdatedAt)
 			continue
 		}
-		importer.buildSet(&setJson)
+		err = importer.downloadBulkFile(ctx, filePath, remote.DownloadUri)
+		if err != nil {
+			return downloaded, err
+		}
+		downloaded = true
+		stat, err := os.Stat(filePath)
+		if err != nil {
+			return downloaded, err
+		}
+		localBulkData[bulkDataType] = bulkDataMetadata{bulkDataJsonStruct: remote, FileSize: stat.Size()}
+		err = saveBulkDataMetadata(importer.DataDir, localBulkData)
+		if err != nil {
+			return downloaded, err
+		}
 	}
+	return downloaded, nil
+}
 
-	if importer.DownloadAssets && importer.DisplayProgressBar {
-		importer.bar = pb.New("Download images", 0)
+func (importer *Importer) BuildCardsFromJson() ([]Card, ImportReport, error) {
+	return importer.BuildCardsFromJsonContext(context.Background())
+}
+
+// BuildCardsFromJsonContext is like BuildCardsFromJson but stops spawning new
+// downloads as soon as ctx is done. The downloads already in flight are
+// drained, their partially written files removed and ctx.Err() is returned.
+func (importer *Importer) BuildCardsFromJsonContext(ctx context.Context) ([]Card, ImportReport, error) {
+	importer.flushCards = nil
+	err := importer.buildCards(ctx)
+	if err != nil {
+		return nil, importer.report, err
 	}
+	cards := make([]Card, 0, len(importer.cardCollection))
+	for _, card := range importer.cardCollection {
+		cards = append(cards, *card)
+	}
+	return cards, importer.report, nil
+}
 
-	// Fill importer.cardCollection
-	streamer, err := NewJsonStreamer(filepath.Join(importer.DataDir, "all_cards.json"))
+// CardsBatchFunc receives the batches of cards built by StreamCardsFromJson.
+// cards are the new cards. updates are cards already received in a previous
+// batch: only their not zero fields, like the name in a new language, are
+// changed and must be written. oracleCards are the oracle cards of cards not
+// already passed in a previous batch, or built from a newer printing.
+type CardsBatchFunc func(cards, updates []Card, oracleCards []OracleCard) error
+
+// StreamCardsFromJson is like BuildCardsFromJson but, instead of keeping all
+// cards in memory, passes them to flush in batches of batchSize cards as the
+// cards file is read. Use BulkInsert and BulkUpdate to store the batches in
+// the database.
+func (importer *Importer) StreamCardsFromJson(batchSize int, flush CardsBatchFunc) (ImportReport, error) {
+	return importer.StreamCardsFromJsonContext(context.Background(), batchSize, flush)
+}
+
+func (importer *Importer) StreamCardsFromJsonContext(ctx context.Context, batchSize int, flush CardsBatchFunc) (ImportReport, error) {
+	importer.batchSize = batchSize
+	importer.flushCards = flush
+	importer.flushedCards = make(map[string]struct{})
+	importer.cardUpdates = make(map[string]*Card)
+	importer.oraclePrintings = make(map[string]oraclePrinting)
+	err := importer.buildCards(ctx)
+	importer.flushCards = nil
+	importer.flushedCards = nil
+	importer.cardUpdates = nil
+	importer.oraclePrintings = nil
+	return importer.report, err
+}
+
+// buildCards builds the collections and downloads the images. When
+// importer.flushCards is set the cards are flushed in batches.
+func (importer *Importer) buildCards(ctx context.Context) (err error) {
+	defer func() {
+		removeErr := removeAllFilesByExtension(SetImagesDir(importer.ImagesDir), "svg")
+		if err == nil {
+			err = removeErr
+		}
+	}()
+
+	importer.ctx = ctx
+	importer.report = ImportReport{CardsPerSet: make(map[string]int)}
+	importer.cardPatches, err = importer.loadCardPatches()
 	if err != nil {
-		panic(err)
+		return err
 	}
-	for streamer.Next() {
-		var cardJson cardJsonStruct
-		err := streamer.Get(&cardJson)
+	importer.cardCollection = make(map[string]*Card)
+	importer.filteredCards = make(map[string]struct{})
+	importer.notEnCards = make(map[string]struct{})
+	importer.setCollection = make(map[string]*Set)
+	importer.rulingsCollection = make(map[string]Rulings)
+	importer.pricesDate = importer.currentPricesDate()
+	if importer.DownloadAssets {
+		err = createDirIfNotExist(SetImagesDir(importer.ImagesDir))
 		if err != nil {
-			panic(err)
+			return err
 		}
-		if len(importer.OnlyTheseSetCodes) != 0 && !contains(importer.OnlyTheseSetCodes, cardJson.SetCode) {
-			continue
+		importer.setIconsDownloaded = make(map[string]struct{})
+		importer.notEnImagesToDownload = make(map[string]downloadJob)
+		importer.bar = nil
+		if importer.DisplayProgressBar {
+			importer.bar = pb.New("Download images", 0)
 		}
-		importer.buildCard(&cardJson)
+		importer.startDownloaders()
 	}
 
+	buildErr := importer.buildCollections()
+
+	stopPhase := importer.report.startPhase("downloads")
 	if importer.DownloadAssets {
-		for _, cardJson := range importer.notEnImagesToDownload {
-			if importer.bar != nil {
-				importer.bar.IncrementMax()
+		for _, job := range importer.notEnImagesToDownload {
+			if buildErr != nil || ctx.Err() != nil {
+				break
 			}
-			importer.wg.Add(1)
-			go importer.downloadCardImage(*cardJson, "en")
+			importer.enqueueDownload(job)
 		}
+		// Always drain the downloads in flight, also on error
+		importer.stopDownloaders()
 		if importer.bar != nil {
 			importer.bar.Finishln()
 		}
 	}
+	stopPhase()
+	if buildErr != nil {
+		return buildErr
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	importer.report.fillSets(importer.setCollection)
+	importer.report.countCards(importer.cardCollection)
+	importer.report.FilteredCards = len(importer.filteredCards)
+	importer.report.StalePatches = importer.cardPatches.stale()
+	return nil
+}
 
-	waitErrors(&importer.wg, importer.errorsChan)
-	close(importer.errorsChan)
-	cards := make([]Card, 0, len(importer.cardCollection))
-	for _, card := range importer.cardCollection {
-		cards = append(cards, *card)
+// Sets returns the sets built by the last import, sorted by code.
+func (importer *Importer) Sets() []Set {
+	sets := make([]Set, 0, len(importer.setCollection))
+	for _, set := range importer.setCollection {
+		sets = append(sets, *set)
 	}
-	return cards, importer.downloadedImagesCount
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].Code < sets[j].Code
+	})
+	return sets
+}
+
+// BulkInsertSets inserts sets into db, updating the ones with the same code.
+func BulkInsertSets(db *gorm.DB, sets []Set) error {
+	if len(sets) == 0 {
+		return nil
+	}
+	scope := db.Clauses(clause.OnConflict{UpdateAll: true}).Session(&gorm.Session{CreateBatchSize: 500})
+	return scope.Create(sets).Error
 }
 
 func BulkInsert(db *gorm.DB, cards []Card) error {
+	if len(cards) == 0 {
+		return nil
+	}
 	sets := make(map[string]*Set)
 	for _, card := range cards {
 		if _, found := sets[card.SetCode]; !found && card.SetCode != "" {
@@ -190,13 +390,217 @@ func BulkInsert(db *gorm.DB, cards []Card) error {
 	for _, set := range sets {
 		allSets = append(allSets, *set)
 	}
+	err := BulkInsertSets(db, allSets)
+	if err != nil {
+		return err
+	}
 
 	scope := db.Clauses(clause.OnConflict{UpdateAll: true}).Session(&gorm.Session{CreateBatchSize: 500})
-	err := scope.Create(allSets).Error
+	err = scope.Omit("Set", "Faces", "Relations", "Translations").Create(cards).Error
 	if err != nil {
 		return err
 	}
-	return scope.Omit("Set").Create(cards).Error
+	return saveCardAssociations(db, cards)
+}
+
+// saveCardAssociations replaces the stored faces, relations and translations
+// of cards with their Faces, Relations and Translations. The IDs of upserted cards are not reliable, so
+// they are looked up by set code and collector number.
+func saveCardAssociations(db *gorm.DB, cards []Card) error {
+	ids, err := storedCardIds(db, cards)
+	if err != nil {
+		return err
+	}
+	cardIds := make([]uint, 0, len(cards))
+	faces := make([]CardFace, 0)
+	relations := make([]CardRelation, 0)
+	translations := make([]CardTranslation, 0)
+	for _, card := range cards {
+		id, found := ids[card.SetCode+"-"+card.CollectorNumber]
+		if !found {
+			continue
+		}
+		cardIds = append(cardIds, id)
+		for _, face := range card.Faces {
+			face.ID = 0
+			face.CardID = id
+			faces = append(faces, face)
+		}
+		for _, relation := range card.Relations {
+			relation.ID = 0
+			relation.CardID = id
+			relations = append(relations, relation)
+		}
+		for _, translation := range card.Translations {
+			translation.ID = 0
+			translation.CardID = id
+			translations = append(translations, translation)
+		}
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := eachIdsChunk(cardIds, func(chunk []uint) error {
+			for _, model := range []interface{}{&CardFace{}, &CardRelation{}, &CardTranslation{}} {
+				err := tx.Where("card_id IN ?", chunk).Delete(model).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		scope := tx.Session(&gorm.Session{CreateBatchSize: 500})
+		if len(faces) > 0 {
+			err = scope.Create(faces).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(relations) > 0 {
+			err = scope.Create(relations).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(translations) > 0 {
+			err = scope.Create(translations).Error
+		}
+		return err
+	})
+}
+
+// maxIdsPerQuery is the most IDs bound in a single query: MySQL refuses
+// prepared statements with more than 65,535 placeholders.
+const maxIdsPerQuery = 1000
+
+// eachIdsChunk calls f with ids split in chunks of at most maxIdsPerQuery
+// IDs.
+func eachIdsChunk(ids []uint, f func(chunk []uint) error) error {
+	for start := 0; start < len(ids); start += maxIdsPerQuery {
+		end := start + maxIdsPerQuery
+		if end > len(ids) {
+			end = len(ids)
+		}
+		err := f(ids[start:end])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eachCardKeysChunk calls f with the set codes and collector numbers of the
+// cards in cardsLists, without duplicates, split in chunks of at most
+// maxIdsPerQuery keys to bind to "(set_code, collector_number) IN ?".
+func eachCardKeysChunk(f func(keys [][]interface{}) error, cardsLists ...[]Card) error {
+	keys := make([][]interface{}, 0)
+	seen := make(map[string]struct{})
+	for _, cards := range cardsLists {
+		for _, card := range cards {
+			key := card.SetCode + "-" + card.CollectorNumber
+			if _, found := seen[key]; !found {
+				seen[key] = struct{}{}
+				keys = append(keys, []interface{}{card.SetCode, card.CollectorNumber})
+			}
+		}
+	}
+	for start := 0; start < len(keys); start += maxIdsPerQuery {
+		end := start + maxIdsPerQuery
+		if end > len(keys) {
+			end = len(keys)
+		}
+		err := f(keys[start:end])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storedCardIds returns the IDs of the stored cards among cards by set code
+// and collector number.
+func storedCardIds(db *gorm.DB, cards []Card) (map[string]uint, error) {
+	ids := make(map[string]uint, len(cards))
+	err := eachCardKeysChunk(func(keys [][]interface{}) error {
+		storedCards := make([]Card, 0, len(keys))
+		err := db.Select("id", "set_code", "collector_number").Where("(set_code, collector_number) IN ?", keys).Find(&storedCards).Error
+		if err != nil {
+			return err
+		}
+		for _, card := range storedCards {
+			ids[card.SetCode+"-"+card.CollectorNumber] = card.ID
+		}
+		return nil
+	}, cards)
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// BulkUpdate writes the not zero fields of each card in cards into the stored
+// card with the same set code and collector number. Their translations are
+// added to the stored ones and their faces replace the stored ones.
+func BulkUpdate(db *gorm.DB, cards []Card) error {
+	if len(cards) == 0 {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, card := range cards {
+			err := tx.Model(&Card{}).Where("set_code = ? AND collector_number = ?", card.SetCode, card.CollectorNumber).Omit("Set", "Faces", "Relations", "Translations").Updates(card).Error
+			if err != nil {
+				return err
+			}
+		}
+		err := replaceCardFaces(tx, cards)
+		if err != nil {
+			return err
+		}
+		return saveCardTranslations(tx, cards)
+	})
+}
+
+// replaceCardFaces replaces the stored faces of the partial updates in cards
+// with Faces.
+func replaceCardFaces(db *gorm.DB, cards []Card) error {
+	withFaces := make([]Card, 0)
+	for _, card := range cards {
+		if len(card.Faces) > 0 {
+			withFaces = append(withFaces, card)
+		}
+	}
+	if len(withFaces) == 0 {
+		return nil
+	}
+	ids, err := storedCardIds(db, withFaces)
+	if err != nil {
+		return err
+	}
+	cardIds := make([]uint, 0, len(withFaces))
+	faces := make([]CardFace, 0)
+	for _, card := range withFaces {
+		id, found := ids[card.SetCode+"-"+card.CollectorNumber]
+		if !found {
+			continue
+		}
+		cardIds = append(cardIds, id)
+		for _, face := range card.Faces {
+			face.ID = 0
+			face.CardID = id
+			faces = append(faces, face)
+		}
+	}
+	if len(faces) == 0 {
+		return nil
+	}
+	err = eachIdsChunk(cardIds, func(chunk []uint) error {
+		return db.Where("card_id IN ?", chunk).Delete(&CardFace{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	return db.Session(&gorm.Session{CreateBatchSize: 500}).Create(faces).Error
 }
 
 func FillMissingTranslations(db *gorm.DB) error {
@@ -225,6 +629,8 @@ func FillMissingTranslations(db *gorm.DB) error {
 type bulkDataJsonStruct struct {
 	Type        string `json:"type"`
 	DownloadUri string `json:"download_uri"`
+	UpdatedAt   string `json:"updated_at"`
+	Size        int64  `json:"size"`
 }
 
 type bulkDataArrayJsonStruct struct {
@@ -242,6 +648,7 @@ type setJsonStruct struct {
 	IconSvgUri    string `json:"icon_svg_uri"`
 	ParentSetCode string `json:"parent_set_code"`
 	SetType       string `json:"set_type"`
+	Digital       bool   `json:"digital"`
 }
 
 type rulingsJsonStruct struct {
@@ -265,12 +672,17 @@ func (setJson *setJsonStruct) getParentCode() (code string) {
 }
 
 type cardJsonStruct struct {
-	Name        string               `json:"name"`
-	PrintedName string               `json:"printed_name"`
-	Lang        string               `json:"lang"`
-	ImageUris   imagesCardJsonStruct `json:"image_uris"`
-	CardFaces   []cardFaceStruct     `json:"card_faces"`
-	SetType     string               `json:"set_type"`
+	Name            string               `json:"name"`
+	PrintedName     string               `json:"printed_name"`
+	PrintedTypeLine string               `json:"printed_type_line"`
+	PrintedText     string               `json:"printed_text"`
+	Lang            string               `json:"lang"`
+	ImageUris       imagesCardJsonStruct `json:"image_uris"`
+	CardFaces       []cardFaceStruct     `json:"card_faces"`
+	SetType         string               `json:"set_type"`
+	Prices          pricesCardJsonStruct `json:"prices"`
+
+	AllParts []relatedCardJsonStruct `json:"all_parts"`
 
 	SetCode string `json:"set"`
 
@@ -360,8 +772,11 @@ func (imagesCardJson *imagesCardJsonStruct) GetImageByTypeName(name string) stri
 }
 
 type cardFaceStruct struct {
-	PrintedName string               `json:"printed_name"`
-	ImageUris   imagesCardJsonStruct `json:"image_uris"`
+	Name            string               `json:"name"`
+	PrintedName     string               `json:"printed_name"`
+	PrintedTypeLine string               `json:"printed_type_line"`
+	PrintedText     string               `json:"printed_text"`
+	ImageUris       imagesCardJsonStruct `json:"image_uris"`
 
 	Artist         string   `json:"artist"`
 	CMC            float32  `json:"cmc"`
@@ -380,13 +795,27 @@ type cardFaceStruct struct {
 
 // PRIVATE functions
 
-func fetchAllCardsDataUrl() (map[string]string, error) {
-	resp, err := http.Get("https://api.scryfall.com/bulk-data")
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+// fetchBulkData returns the bulk data entries available on Scryfall indexed by
+// type.
+func (importer *Importer) fetchBulkData(ctx context.Context) (map[string]bulkDataJsonStruct, error) {
+	var body []byte
+	url := importer.ScryfallApiUrl + "/bulk-data"
+	err := retryOnError(ctx, importer.RetryAttempts, importer.RetryDelay, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := importer.doRequest(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return httpError(url, resp)
+		}
+		body, err = ioutil.ReadAll(resp.Body)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -395,11 +824,180 @@ func fetchAllCardsDataUrl() (map[string]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	urls := make(map[string]string)
+	bulkDataByType := make(map[string]bulkDataJsonStruct)
 	for _, bulkData := range bulkDataArray.Data {
-		urls[bulkData.Type] = bulkData.DownloadUri
+		bulkDataByType[bulkData.Type] = bulkData
+	}
+	return bulkDataByType, nil
+}
+
+// bulkDataFilePath returns the path of the bulk data file of type
+// bulkDataType, gzip compressed if importer.CompressBulkData is set.
+func (importer *Importer) bulkDataFilePath(bulkDataType string) string {
+	fileName := fmt.Sprintf("%s.json", bulkDataType)
+	if importer.CompressBulkData {
+		fileName += ".gz"
+	}
+	return filepath.Join(importer.DataDir, fileName)
+}
+
+// buildCollections fills importer.rulingsCollection, importer.setCollection and
+// importer.cardCollection from the JSON files in importer.DataDir.
+func (importer *Importer) buildCollections() error {
+	if importer.Snapshot != "" {
+		if _, err := os.Stat(importer.sourceFilePath("")); err != nil {
+			return fmt.Errorf("snapshot `%s` not found: %w", importer.Snapshot, err)
+		}
+	}
+
+	// Fill importer.rulingsCollection
+	stopPhase := importer.report.startPhase("rulings")
+	rulingsStreamer, err := NewJsonStreamer(importer.sourceBulkDataFilePath("rulings"))
+	if err != nil {
+		return err
+	}
+	defer rulingsStreamer.Close()
+	for rulingsStreamer.Next() {
+		var rulingJson rulingsJsonStruct
+		err = rulingsStreamer.Get(&rulingJson)
+		if err != nil {
+			return err
+		}
+		importer.buildRuling(&rulingJson)
+	}
+	if rulingsStreamer.Err() != nil {
+		return rulingsStreamer.Err()
+	}
+	stopPhase()
+
+	// Fill importer.setCollection
+	stopPhase = importer.report.startPhase("sets")
+	setsJson := setsJsonStruct{}
+	err = loadFile(importer.sourceFilePath("all_sets.json"), &setsJson)
+	if err != nil {
+		return err
 	}
-	return urls, nil
+	importer.selectedSets = importer.selectSets(setsJson.Data)
+	for _, setJson := range setsJson.Data {
+		if !importer.isSetCodeSelected(setJson.Code) {
+			continue
+		}
+		err = importer.buildSet(&setJson)
+		if err != nil {
+			return err
+		}
+	}
+	stopPhase()
+
+	// Fill importer.cardCollection
+	defer importer.report.startPhase("cards")()
+	streamer, err := NewJsonStreamer(importer.sourceBulkDataFilePath(importer.BulkDataType))
+	if err != nil {
+		return err
+	}
+	defer streamer.Close()
+	for importer.ctx.Err() == nil && streamer.Next() {
+		var cardJson cardJsonStruct
+		err := streamer.Get(&cardJson)
+		if err != nil {
+			return err
+		}
+		if !importer.isSetCodeSelected(cardJson.SetCode) {
+			importer.cardPatches.skip(cardJson.ScryfallID, cardJson.SetCode, cardJson.CollectorNumber)
+			continue
+		}
+		err = importer.buildCard(&cardJson)
+		if err != nil {
+			return err
+		}
+		if importer.flushCards != nil && importer.flushableCards() >= importer.batchSize {
+			err = importer.flushBatch()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if streamer.Err() != nil {
+		return streamer.Err()
+	}
+	if importer.ExcludeNotEnCards {
+		importer.dropNotEnCards()
+	}
+	if importer.flushCards != nil && importer.ctx.Err() == nil {
+		return importer.flushBatch()
+	}
+	return nil
+}
+
+// flushBatch passes the cards built so far, and the updates of the cards
+// already flushed, to importer.flushCards and forgets them. If
+// importer.ExcludeNotEnCards is true the cards without an English printing
+// yet are kept, since they could be dropped at the end.
+func (importer *Importer) flushBatch() error {
+	if importer.flushableCards() == 0 && len(importer.cardUpdates) == 0 {
+		return nil
+	}
+	cards := make([]Card, 0, importer.flushableCards())
+	flushed := make([]*Card, 0, importer.flushableCards())
+	for key, card := range importer.cardCollection {
+		if _, notEn := importer.notEnCards[key]; notEn && importer.ExcludeNotEnCards {
+			continue
+		}
+		importer.report.CardsPerSet[card.SetCode]++
+		cards = append(cards, *card)
+		flushed = append(flushed, card)
+		importer.flushedCards[key] = struct{}{}
+		delete(importer.cardCollection, key)
+	}
+	updates := make([]Card, 0, len(importer.cardUpdates))
+	for _, card := range importer.cardUpdates {
+		updates = append(updates, *card)
+	}
+	importer.cardUpdates = make(map[string]*Card)
+	oracleCards := buildOracleCards(flushed, importer.oraclePrintings)
+	return importer.flushCards(cards, updates, oracleCards)
+}
+
+func (importer *Importer) flushableCards() int {
+	if importer.ExcludeNotEnCards {
+		return len(importer.cardCollection) - len(importer.notEnCards)
+	}
+	return len(importer.cardCollection)
+}
+
+// dropNotEnCards filters out the cards without an English printing.
+func (importer *Importer) dropNotEnCards() {
+	for key := range importer.notEnCards {
+		delete(importer.cardCollection, key)
+		delete(importer.notEnImagesToDownload, key)
+		importer.filteredCards[key] = struct{}{}
+	}
+	importer.notEnCards = make(map[string]struct{})
+}
+
+func (importer *Importer) buildCardFaces(cardJson *cardJsonStruct) []CardFace {
+	faces := make([]CardFace, 0, len(cardJson.CardFaces))
+	for i, faceJson := range cardJson.CardFaces {
+		faces = append(faces, CardFace{
+			Ordinal:        i,
+			Name:           faceJson.Name,
+			Artist:         faceJson.Artist,
+			CMC:            faceJson.CMC,
+			ColorIndicator: faceJson.ColorIndicator,
+			Colors:         faceJson.Colors,
+			FlavorText:     faceJson.FlavorText,
+			Layout:         faceJson.Layout,
+			Loyalty:        faceJson.Loyalty,
+			ManaCost:       faceJson.ManaCost,
+			OracleText:     faceJson.OracleText,
+			Power:          faceJson.Power,
+			Toughness:      faceJson.Toughness,
+			TypeLine:       faceJson.TypeLine,
+			Watermark:      faceJson.Watermark,
+			ImageUrl:       faceJson.ImageUris.GetImageByTypeName(importer.ImageType),
+		})
+	}
+	return faces
 }
 
 func (importer *Importer) buildRuling(rulingJson *rulingsJsonStruct) {
@@ -411,7 +1009,7 @@ func (importer *Importer) buildRuling(rulingJson *rulingsJsonStruct) {
 	importer.rulingsCollection[rulingJson.OracleId] = append(importer.rulingsCollection[rulingJson.OracleId], ruling)
 }
 
-func (importer *Importer) buildSet(setJson *setJsonStruct) {
+func (importer *Importer) buildSet(setJson *setJsonStruct) error {
 	iconName := setJson.getIconName()
 	if _, found := importer.setCollection[setJson.Code]; !found {
 		set := &Set{
@@ -425,22 +1023,43 @@ func (importer *Importer) buildSet(setJson *setJsonStruct) {
 		if err == nil {
 			set.ReleasedAt = &releasedAt
 		}
+		err = importer.runSetHooks(setJson, set)
+		if err != nil {
+			return err
+		}
 		importer.setCollection[setJson.Code] = set
 
 		if importer.DownloadAssets {
-			createDirIfNotExist(filepath.Join(CardImagesDir(importer.ImagesDir), setJson.Code))
+			err := createDirIfNotExist(filepath.Join(CardImagesDir(importer.ImagesDir), setJson.Code))
+			if err != nil {
+				return err
+			}
 			if _, found := importer.setIconsDownloaded[iconName]; !found {
 				importer.setIconsDownloaded[iconName] = struct{}{}
-				importer.wg.Add(1)
-				go importer.downloadSetIcon(*setJson)
+				setJsonCopy := *setJson
+				importer.enqueueDownload(downloadJob{setJson: &setJsonCopy})
 			}
 		}
 	}
+	return nil
 }
 
-func (importer *Importer) buildCard(cardJson *cardJsonStruct) {
+func (importer *Importer) buildCard(cardJson *cardJsonStruct) error {
 	key := fmt.Sprintf("%s-%s", cardJson.SetCode, cardJson.CollectorNumber)
+	if _, filtered := importer.filteredCards[key]; filtered {
+		importer.cardPatches.skip(cardJson.ScryfallID, cardJson.SetCode, cardJson.CollectorNumber)
+		return nil
+	}
 	card, found := importer.cardCollection[key]
+	if _, flushed := importer.flushedCards[key]; !found && flushed {
+		// Another language of a card already flushed: collect only the changes
+		card, found = importer.cardUpdates[key]
+		if !found {
+			card = &Card{SetCode: cardJson.SetCode, CollectorNumber: cardJson.CollectorNumber}
+			importer.cardUpdates[key] = card
+			found = true
+		}
+	}
 	if !found {
 		images := cardJson.getImageUrls(importer.ImageType)
 		releasedAt := parseTime("2006-01-02", cardJson.ReleasedAt)
@@ -492,9 +1111,22 @@ func (importer *Importer) buildCard(cardJson *cardJsonStruct) {
 			CardmarketID: cardJson.CardmarketID,
 
 			Rulings: importer.rulingsCollection[cardJson.OracleID],
+			Price:   cardJson.Prices.newCardPrice(importer.pricesDate),
+
+			Relations: buildCardRelations(cardJson),
+		}
+		if !card.IsValid() {
+			invalidErr := &InvalidCardError{ScryfallID: cardJson.ScryfallID, SetCode: cardJson.SetCode, CollectorNumber: cardJson.CollectorNumber}
+			if !importer.SkipInvalidCards {
+				return invalidErr
+			}
+			log.Println(invalidErr)
+			importer.report.InvalidCards = append(importer.report.InvalidCards, cardJson.ScryfallID)
+			return nil
 		}
 
-		if len(cardJson.CardFaces) == 2 {
+		if len(cardJson.CardFaces) >= 2 {
+			card.Faces = importer.buildCardFaces(cardJson)
 			card.Artist = cardJson.CardFaces[0].Artist
 			card.ArtistBack = cardJson.CardFaces[1].Artist
 			card.CMC = cardJson.CardFaces[0].CMC
@@ -562,23 +1194,35 @@ func (importer *Importer) buildCard(cardJson *cardJsonStruct) {
 			card.Watermark = cardJson.Watermark
 		}
 
+		if importer.isCardFiltered(card) {
+			importer.filteredCards[key] = struct{}{}
+			importer.cardPatches.skip(cardJson.ScryfallID, cardJson.SetCode, cardJson.CollectorNumber)
+			return nil
+		}
+
 		importer.cardCollection[key] = card
+		if cardJson.Lang != "en" {
+			importer.notEnCards[key] = struct{}{}
+		}
 		if importer.DownloadAssets {
-			importer.notEnImagesToDownload[key] = cardJson
+			importer.notEnImagesToDownload[key] = importer.newCardImagesJob(cardJson, "en")
 		}
 	}
 	if cardJson.Lang == "en" {
+		delete(importer.notEnCards, key)
 		images := cardJson.getImageUrls(importer.ImageType)
 		card.FrontImageUrl = images[0]
 		card.BackImageUrl = images[1]
 		card.ScryfallID = cardJson.ScryfallID
+		if len(cardJson.CardFaces) >= 2 {
+			card.Faces = importer.buildCardFaces(cardJson)
+		}
+		if price := cardJson.Prices.newCardPrice(importer.pricesDate); price != nil {
+			card.Price = price
+		}
 	}
 	if importer.DownloadAssets && (!importer.DownloadOnlyEnAssets || cardJson.Lang == "en") {
-		if importer.bar != nil {
-			importer.bar.IncrementMax()
-		}
-		importer.wg.Add(1)
-		go importer.downloadCardImage(*cardJson, cardJson.Lang)
+		importer.enqueueDownload(importer.newCardImagesJob(cardJson, cardJson.Lang))
 		if cardJson.Lang == "en" {
 			delete(importer.notEnImagesToDownload, key)
 		}
@@ -589,71 +1233,162 @@ func (importer *Importer) buildCard(cardJson *cardJsonStruct) {
 	}
 
 	card.SetName(printedName, cardJson.Lang)
+	if cardJson.Lang != "en" {
+		card.setTranslation(importer.buildCardTranslation(cardJson, printedName))
+	}
+	err := importer.cardPatches.apply(card)
+	if err != nil {
+		return err
+	}
+	return importer.runCardHooks(cardJson, card)
+}
+
+// downloadJob is the unit of work of the download workers: the icon of the set
+// setJson or the card images at imageUrls, each one saved in the file path
+// with the same index in filePaths.
+type downloadJob struct {
+	setJson   *setJsonStruct
+	imageUrls []string
+	filePaths []string
+}
 
-	if !card.IsValid() {
-		panic(fmt.Sprintf("Card is not valid: %v", card))
+func (importer *Importer) newCardImagesJob(cardJson *cardJsonStruct, saveAsLang string) downloadJob {
+	job := downloadJob{}
+	images := cardJson.getImageUrls(importer.ImageType)
+	for i, imageUrl := range images {
+		if imageUrl != "" {
+			job.imageUrls = append(job.imageUrls, imageUrl)
+			job.filePaths = append(job.filePaths, CardImagePath(importer.ImagesDir, cardJson.SetCode, cardJson.CollectorNumber, saveAsLang, i == 1))
+		}
+	}
+	// Faces after the second one
+	if hasBackSide(cardJson) {
+		for i := 2; i < len(cardJson.CardFaces); i++ {
+			imageUrl := cardJson.CardFaces[i].ImageUris.GetImageByTypeName(importer.ImageType)
+			if imageUrl != "" {
+				job.imageUrls = append(job.imageUrls, imageUrl)
+				job.filePaths = append(job.filePaths, CardFaceImagePath(importer.ImagesDir, cardJson.SetCode, cardJson.CollectorNumber, saveAsLang, i))
+			}
+		}
+	}
+	return job
+}
+
+// startDownloaders starts the pool of download workers. Download errors are
+// collected in the import report until stopDownloaders is called.
+func (importer *Importer) startDownloaders() {
+	importer.errorsChan = make(chan error, 10)
+	importer.downloadJobs = make(chan downloadJob, importer.downloadConcurrency)
+	workers := importer.downloadConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		importer.wg.Add(1)
+		go importer.downloadWorker()
 	}
+	importer.errorsCollected = make(chan struct{})
+	go func() {
+		for err := range importer.errorsChan {
+			log.Println(err)
+			failure := FailedDownload{Error: err.Error()}
+			var downloadErr *DownloadError
+			if errors.As(err, &downloadErr) {
+				failure.Url = downloadErr.Url
+				failure.FilePath = downloadErr.FilePath
+				failure.Error = downloadErr.Err.Error()
+			}
+			importer.report.FailedDownloads = append(importer.report.FailedDownloads, failure)
+		}
+		close(importer.errorsCollected)
+	}()
 }
 
-// setJson must be a copy (not a pointer) cause this method is called in a go routine
-func (importer *Importer) downloadSetIcon(setJson setJsonStruct) {
-	defer pushSemaphoreAndDefer(&importer.wg, importer.downloaderSemaphore)()
+// enqueueDownload queues job for the download workers. It blocks while the
+// queue is full, so that the jobs waiting in memory are bounded, and gives up
+// if the import is cancelled.
+func (importer *Importer) enqueueDownload(job downloadJob) {
+	if importer.ctx.Err() != nil {
+		return
+	}
+	if importer.bar != nil {
+		importer.bar.IncrementMax()
+	}
+	select {
+	case importer.downloadJobs <- job:
+	case <-importer.ctx.Done():
+	}
+}
 
+// stopDownloaders waits for the queued jobs to be completed, or skipped if the
+// import is cancelled, and for all download errors to be collected.
+func (importer *Importer) stopDownloaders() {
+	close(importer.downloadJobs)
+	importer.wg.Wait()
+	close(importer.errorsChan)
+	<-importer.errorsCollected
+}
+
+func (importer *Importer) downloadWorker() {
+	defer importer.wg.Done()
+	for job := range importer.downloadJobs {
+		if importer.ctx.Err() != nil {
+			continue
+		}
+		if job.setJson != nil {
+			importer.downloadSetIcon(job.setJson)
+		}
+		for i, imageUrl := range job.imageUrls {
+			importer.downloadImage(imageUrl, job.filePaths[i])
+		}
+		if importer.bar != nil {
+			importer.bar.Increment()
+		}
+	}
+}
+
+func (importer *Importer) downloadSetIcon(setJson *setJsonStruct) {
 	iconName := setJson.getIconName()
 	svgFilePath := filepath.Join(SetImagesDir(importer.ImagesDir), fmt.Sprintf("%s.svg", iconName))
 	setIconFilePath := SetImagePath(importer.ImagesDir, iconName)
 	if _, err := os.Stat(setIconFilePath); importer.ForceDownloadAssets || os.IsNotExist(err) {
-		err := downloadFile(svgFilePath, setJson.IconSvgUri)
+		err := importer.downloadFile(importer.ctx, svgFilePath, setJson.IconSvgUri)
 		if err != nil {
-			importer.errorsChan <- err
+			importer.pushDownloadError(setJson.IconSvgUri, svgFilePath, err)
 			return
 		}
 
-		err = runCmd("rsvg-convert", svgFilePath, "-b", "white", "-o", setIconFilePath)
+		tmpFilePath := setIconFilePath + ".tmp"
+		err = runCmd(importer.ctx, "rsvg-convert", svgFilePath, "-b", "white", "-o", tmpFilePath)
+		if err == nil {
+			err = syncAndRename(tmpFilePath, setIconFilePath)
+		}
 		if err != nil {
-			importer.errorsChan <- err
+			os.Remove(tmpFilePath)
+			importer.pushDownloadError(setJson.IconSvgUri, setIconFilePath, err)
 		}
 	}
 }
 
-// cardJson must be a copy (not a pointer) cause this method is called in a go routine
-func (importer *Importer) downloadCardImage(cardJson cardJsonStruct, saveAsLang string) {
-	defer pushSemaphoreAndDefer(&importer.wg, importer.downloaderSemaphore)()
-
-	images := cardJson.getImageUrls(importer.ImageType)
-	if images[0] != "" {
-		filePath := CardImagePath(importer.ImagesDir, cardJson.SetCode, cardJson.CollectorNumber, saveAsLang, false)
-		importer.downloadImage(images[0], filePath)
-	}
-	if images[1] != "" {
-		filePath := CardImagePath(importer.ImagesDir, cardJson.SetCode, cardJson.CollectorNumber, saveAsLang, true)
-		importer.downloadImage(images[1], filePath)
-	}
-
-	if importer.bar != nil {
-		importer.bar.Increment()
-	}
-}
-
 func (importer *Importer) downloadImage(imageUrl, filePath string) {
 	var downloadErr error
 	if importer.ForceDownloadAssets {
-		downloadErr = downloadFile(filePath, imageUrl)
+		downloadErr = importer.downloadFile(importer.ctx, filePath, imageUrl)
 		if downloadErr != nil {
-			importer.errorsChan <- downloadErr
+			importer.pushDownloadError(imageUrl, filePath, downloadErr)
 		} else {
-			atomic.AddUint32(&importer.downloadedImagesCount, 1)
+			atomic.AddUint32(&importer.report.ImagesDownloaded, 1)
 		}
 		return
 	}
 
 	stat, err := os.Stat(filePath)
 	if os.IsNotExist(err) {
-		downloadErr = downloadFile(filePath, imageUrl)
+		downloadErr = importer.downloadFile(importer.ctx, filePath, imageUrl)
 		if downloadErr != nil {
-			importer.errorsChan <- downloadErr
+			importer.pushDownloadError(imageUrl, filePath, downloadErr)
 		} else {
-			atomic.AddUint32(&importer.downloadedImagesCount, 1)
+			atomic.AddUint32(&importer.report.ImagesDownloaded, 1)
 		}
 		return
 	}
@@ -666,31 +1401,62 @@ func (importer *Importer) downloadImage(imageUrl, filePath string) {
 		if importer.ForceDownloadDiffSha1 {
 			sha1 = sha1sum(filePath)
 		}
-		downloaded, downloadErr := downloadFileWhenChanged(filePath, imageUrl, stat, sha1)
+		reason, downloadErr := importer.downloadFileWhenChanged(importer.ctx, filePath, imageUrl, stat, sha1)
 		if downloadErr != nil {
-			importer.errorsChan <- downloadErr
-		} else if downloaded {
-			atomic.AddUint32(&importer.downloadedImagesCount, 1)
+			importer.pushDownloadError(imageUrl, filePath, downloadErr)
+			return
+		}
+		if reason != "" {
+			atomic.AddUint32(&importer.report.ImagesDownloaded, 1)
+			importer.reportMutex.Lock()
+			importer.report.ImagesRedownloaded = append(importer.report.ImagesRedownloaded, RedownloadedImage{FilePath: filePath, Url: imageUrl, Reason: reason})
+			importer.reportMutex.Unlock()
+			return
+		}
+	}
+	atomic.AddUint32(&importer.report.ImagesSkipped, 1)
+}
+
+// doRequest sends req with importer.HttpClient, waiting first for the rate
+// limiter of the request host, if any.
+func (importer *Importer) doRequest(req *http.Request) (*http.Response, error) {
+	if limiter, found := importer.rateLimiters[req.URL.Hostname()]; found {
+		err := limiter.Wait(req.Context())
+		if err != nil {
+			return nil, err
 		}
 	}
+	return importer.HttpClient.Do(req)
+}
+
+// pushDownloadError sends err to the errors channel unless the import has
+// been cancelled: in that case the error is just a consequence of the
+// cancellation.
+func (importer *Importer) pushDownloadError(url, filePath string, err error) {
+	if importer.ctx.Err() != nil {
+		return
+	}
+	importer.errorsChan <- &DownloadError{Url: url, FilePath: filePath, Err: err}
 }
 
 func hasBackSide(cardJson *cardJsonStruct) bool {
 	return len(cardJson.CardFaces) > 1 && cardJson.CardFaces[0].ImageUris != (imagesCardJsonStruct{}) && cardJson.CardFaces[1].ImageUris != (imagesCardJsonStruct{})
 }
 
-func getResponseHeader(url string) (http.Header, error) {
-	var (
-		resp *http.Response
-		err  error
-	)
-	retryErr := retryOnError(3, 100*time.Millisecond, func() error {
-		resp, err = http.Head(url)
+func (importer *Importer) getResponseHeader(ctx context.Context, url string) (http.Header, error) {
+	var resp *http.Response
+	retryErr := retryOnError(ctx, importer.RetryAttempts, importer.RetryDelay, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err = importer.doRequest(req)
 		if err != nil {
 			return err
 		}
+		resp.Body.Close()
 		if resp.StatusCode != 200 {
-			return httpError(url, resp.StatusCode)
+			return httpError(url, resp)
 		}
 		return nil
 	})
@@ -700,10 +1466,13 @@ func getResponseHeader(url string) (http.Header, error) {
 	return resp.Header, nil
 }
 
-func downloadFileWhenChanged(filepath, url string, stat os.FileInfo, sha1 string) (bool, error) {
-	header, err := getResponseHeader(url)
+// downloadFileWhenChanged downloads url into filepath only if the remote file
+// is newer than stat or its sha1 differs from sha1. It returns the reason of
+// the download, or an empty string if the file has not been downloaded.
+func (importer *Importer) downloadFileWhenChanged(ctx context.Context, filepath, url string, stat os.FileInfo, sha1 string) (string, error) {
+	header, err := importer.getResponseHeader(ctx, url)
 	if err != nil {
-		return false, err
+		return "", err
 	}
 
 	reDownloadReasons := []string{}
@@ -716,32 +1485,35 @@ func downloadFileWhenChanged(filepath, url string, stat os.FileInfo, sha1 string
 	}
 
 	if len(reDownloadReasons) == 0 {
-		return false, nil
+		return "", nil
 	}
 
-	log.Printf("Force re-download of image file '%s': %s\n", filepath, strings.Join(reDownloadReasons, " and "))
-	return true, downloadFile(filepath, url)
+	reason := strings.Join(reDownloadReasons, " and ")
+	log.Printf("Force re-download of image file '%s': %s\n", filepath, reason)
+	return reason, importer.downloadFile(ctx, filepath, url)
 }
 
-func downloadFile(filepath, url string) error {
-	return retryOnError(3, 100*time.Millisecond, func() error {
-		resp, err := http.Get(url)
+func (importer *Importer) downloadFile(ctx context.Context, filepath, url string) error {
+	return retryOnError(ctx, importer.RetryAttempts, importer.RetryDelay, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := importer.doRequest(req)
 		if err != nil {
 			return err
 		}
 		defer resp.Body.Close()
 		if resp.StatusCode != 200 {
-			return httpError(url, resp.StatusCode)
+			return httpError(url, resp)
 		}
 
-		file, err := os.Create(filepath)
-		if err != nil {
-			return err
+		expectedSize := resp.ContentLength
+		if resp.Uncompressed {
+			// Content-Length refers to the compressed body
+			expectedSize = -1
 		}
-		defer file.Close()
-
-		_, err = io.Copy(file, resp.Body)
-		return err
+		return writeFileAtomically(filepath, resp.Body, expectedSize)
 	})
 }
 
@@ -779,13 +1551,25 @@ func sha1sum(filepath string) string {
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
-func httpError(url string, statusCode int) error {
-	return fmt.Errorf("download file `%s` failed with status code %d", url, statusCode)
+func httpError(url string, resp *http.Response) error {
+	return &HttpError{Url: url, StatusCode: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
 }
 
-func runCmd(arg string, args ...string) error {
+// parseRetryAfter parses the value of a Retry-After header, that can be a
+// number of seconds or a HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+func runCmd(ctx context.Context, arg string, args ...string) error {
 	var stderr bytes.Buffer
-	cmd := exec.Command(arg, args...)
+	cmd := exec.CommandContext(ctx, arg, args...)
 	cmd.Stderr = &stderr
 	err := cmd.Run()
 	if err != nil {
@@ -795,7 +1579,7 @@ func runCmd(arg string, args ...string) error {
 }
 
 func loadFile(filePath string, out interface{}) error {
-	file, err := os.Open(filePath)
+	file, err := openDataFile(filePath)
 	if err != nil {
 		return err
 	}
@@ -816,6 +1600,54 @@ func loadFile(filePath string, out interface{}) error {
 
 // Utils
 
+// writeFileAtomically writes the content of reader into filePath. The content
+// is written into a temporary file in the same directory, synced to disk and
+// then renamed to filePath, so that filePath is never left truncated. If
+// expectedSize is not negative and the size of the content is different, the
+// temporary file is removed and filePath is left untouched.
+func writeFileAtomically(filePath string, reader io.Reader, expectedSize int64) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filePath), fmt.Sprintf(".%s.*.tmp", filepath.Base(filePath)))
+	if err != nil {
+		return err
+	}
+	written, err := io.Copy(tmpFile, reader)
+	if err == nil && expectedSize >= 0 && written != expectedSize {
+		err = fmt.Errorf("file `%s` is truncated: %d bytes of %d written", filePath, written, expectedSize)
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), filePath)
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+	}
+	return err
+}
+
+// syncAndRename flushes the file at tmpFilePath to disk and renames it to
+// filePath.
+func syncAndRename(tmpFilePath, filePath string) error {
+	file, err := os.OpenFile(tmpFilePath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFilePath, filePath)
+}
+
 func contains(collection []string, s string) bool {
 	for _, ss := range collection {
 		if ss == s {
@@ -833,26 +1665,25 @@ func doubleSideCardWithSameName(s string) string {
 	return parts[0]
 }
 
-func removeAllFilesByExtension(dirPath, ext string) {
+func removeAllFilesByExtension(dirPath, ext string) error {
 	files, err := filepath.Glob(filepath.Join(dirPath, fmt.Sprintf("*.%s", ext)))
 	if err != nil {
-		panic(err)
+		return err
 	}
 	for _, file := range files {
 		err = os.Remove(file)
 		if err != nil {
-			panic(err)
+			return err
 		}
 	}
+	return nil
 }
 
-func createDirIfNotExist(path string) {
+func createDirIfNotExist(path string) error {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
-		err = os.MkdirAll(path, os.ModePerm)
-		if err != nil {
-			panic(err)
-		}
+		return os.MkdirAll(path, os.ModePerm)
 	}
+	return nil
 }
 
 func parseTime(format, timeString string) *time.Time {
@@ -863,46 +1694,70 @@ func parseTime(format, timeString string) *time.Time {
 	return &t
 }
 
-func retryOnError(attempts int, delay time.Duration, f func() error) error {
+// maxRetryDelay caps the exponential backoff between two retries.
+const maxRetryDelay = 30 * time.Second
+
+// retryOnError calls f until it succeeds, up to attempts times and at least
+// once. Between two attempts it waits an exponential backoff, starting from
+// delay, with jitter or the time requested by the server with the Retry-After
+// header. Permanent errors, like a 404 response, are not retried.
+func retryOnError(ctx context.Context, attempts int, delay time.Duration, f func() error) error {
+	if attempts < 1 {
+		attempts = 1
+	}
 	var err error
 	retryCount := 0
 	for {
-		if retryCount == attempts {
-			break
-		}
 		err = f()
 		if err == nil {
 			break
 		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !isRetryable(err) {
+			return err
+		}
 		retryCount += 1
+		if retryCount == attempts {
+			break
+		}
 		log.Printf("[Retry] Action failed (attempt #%d): %s\n", retryCount, err)
-		time.Sleep(delay)
+		select {
+		case <-time.After(retryDelay(delay, retryCount, err)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 	return err
 }
 
-func pushSemaphoreAndDefer(wg *sync.WaitGroup, semaphore chan struct{}) func() {
-	semaphore <- struct{}{}
-	return func() {
-		wg.Done()
-		<-semaphore
+// retryDelay returns how long to wait before the retry number retryCount of an
+// action failed with err.
+func retryDelay(delay time.Duration, retryCount int, err error) time.Duration {
+	if delay <= 0 {
+		delay = 0
+	}
+	backoff := delay << uint(retryCount-1)
+	// A not positive backoff of a positive delay is an overflow
+	if backoff > maxRetryDelay || (delay > 0 && backoff <= 0) {
+		backoff = maxRetryDelay
+	}
+	// Jitter between half and the full backoff
+	backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+	var httpErr *HttpError
+	if errors.As(err, &httpErr) && httpErr.RetryAfter > backoff {
+		return httpErr.RetryAfter
 	}
+	return backoff
 }
 
-func waitErrors(wg *sync.WaitGroup, readChannel chan error) {
-	quit := make(chan bool)
-
-	go func() {
-		wg.Wait()
-		quit <- true
-	}()
-
-	for {
-		select {
-		case err := <-readChannel:
-			log.Println(err)
-		case <-quit:
-			return
-		}
+// isRetryable returns false for the errors that will not go away retrying the
+// action, like a 404 response.
+func isRetryable(err error) bool {
+	var httpErr *HttpError
+	if errors.As(err, &httpErr) {
+		return httpErr.Temporary()
 	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
 }
@@ -1,4 +1,9 @@
 package mtgdb
 
-var DownloadFile = downloadFile
-var DownloadFileWhenChanged = downloadFileWhenChanged
+var DownloadFile = (*Importer).downloadFile
+var DownloadFileWhenChanged = (*Importer).downloadFileWhenChanged
+var ChangedFields = changedFields
+var RemoveCardImages = removeCardImages
+var MergeStoredLanguages = mergeStoredLanguages
+var EachIdsChunk = eachIdsChunk
+var EachCardKeysChunk = eachCardKeysChunk
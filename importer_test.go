@@ -1,10 +1,22 @@
 package mtgdb_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sort"
+	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -18,6 +30,678 @@ import (
 const FIXTURES_PATH = "./testdata"
 const TEMP_DIR = "/tmp/mtgdb_test"
 
+// scryfallTransport redirects every request to the test server, so that also
+// the image URLs found in the fixtures are served offline.
+type scryfallTransport struct {
+	server *httptest.Server
+}
+
+func (transport *scryfallTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	serverUrl, _ := url.Parse(transport.server.URL)
+	req = req.Clone(req.Context())
+	req.URL.Scheme = serverUrl.Scheme
+	req.URL.Host = serverUrl.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newScryfallServer() *httptest.Server {
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sets", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join(FIXTURES_PATH, "data", "all_sets.json"))
+	})
+	mux.HandleFunc("/bulk-data", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"data":[{"type":"all_cards","download_uri":"%[1]s/bulk/all_cards.json"},{"type":"default_cards","download_uri":"%[1]s/bulk/all_cards.json"},{"type":"rulings","download_uri":"%[1]s/bulk/rulings.json"}]}`, server.URL)
+	})
+	mux.HandleFunc("/bulk/", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join(FIXTURES_PATH, "data", filepath.Base(r.URL.Path)))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "image %s", r.URL.Path)
+	})
+	server = httptest.NewServer(mux)
+	return server
+}
+
+func TestImporterDownloadDataOffline(t *testing.T) {
+	defer os.RemoveAll(TEMP_DIR)
+	server := newScryfallServer()
+	defer server.Close()
+
+	importer := mtgdb.NewImporter(TEMP_DIR)
+	importer.ScryfallApiUrl = server.URL
+	importer.HttpClient = &http.Client{Transport: &scryfallTransport{server: server}}
+	importer.DownloadOnlyEnAssets = false
+	err := importer.DownloadData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fileName := range []string{"all_sets.json", "all_cards.json", "rulings.json"} {
+		_, err = os.Stat(filepath.Join(TEMP_DIR, fileName))
+		assert.False(t, os.IsNotExist(err), fileName)
+	}
+
+	collection, report, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 10, len(collection))
+	assert.Equal(t, uint32(44), report.ImagesDownloaded)
+	_, err = os.Stat(filepath.Join(importer.ImagesDir, "/cards/war/war_169★_ja.jpg"))
+	assert.False(t, os.IsNotExist(err))
+
+	// Report
+	assert.Equal(t, []string{"eld", "isd", "peld", "sld", "teld", "ust", "war"}, report.Sets)
+	assert.Equal(t, map[string]int{"eld": 3, "isd": 1, "peld": 2, "sld": 1, "teld": 1, "ust": 1, "war": 1}, report.CardsPerSet)
+	assert.Equal(t, uint32(0), report.ImagesSkipped)
+	phases := make([]string, 0)
+	for _, phase := range report.Phases {
+		phases = append(phases, phase.Name)
+	}
+	assert.Equal(t, []string{"rulings", "sets", "cards", "downloads"}, phases)
+
+	// Images already present are skipped
+	_, report, err = importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint32(0), report.ImagesDownloaded)
+	assert.Equal(t, uint32(44), report.ImagesSkipped)
+}
+
+func TestImporterBuildCardsFromJsonReportFailedDownloads(t *testing.T) {
+	defer os.RemoveAll(TEMP_DIR)
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	importer := mtgdb.NewImporter(filepath.Join(FIXTURES_PATH, "data"))
+	importer.ImagesDir = filepath.Join(TEMP_DIR, "images")
+	importer.OnlyTheseSetCodes = []string{"isd"}
+	importer.HttpClient = &http.Client{Transport: &scryfallTransport{server: server}}
+	_, report, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint32(0), report.ImagesDownloaded)
+	// Set icon, front and back image
+	assert.Equal(t, 3, len(report.FailedDownloads))
+	for _, failure := range report.FailedDownloads {
+		assert.Contains(t, failure.Error, "status code 404")
+		assert.Contains(t, failure.Url, "scryfall")
+		assert.NotEmpty(t, failure.FilePath)
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, string(data), `"failed_downloads":[{`)
+}
+
+func TestImporterDownloadDataBulkDataType(t *testing.T) {
+	defer os.RemoveAll(TEMP_DIR)
+	server := newScryfallServer()
+	defer server.Close()
+
+	importer := mtgdb.NewImporter(TEMP_DIR)
+	importer.ScryfallApiUrl = server.URL
+	importer.BulkDataType = mtgdb.BulkDataDefaultCards
+	importer.DownloadAssets = false
+	err := importer.DownloadData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(filepath.Join(TEMP_DIR, "default_cards.json"))
+	assert.False(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(TEMP_DIR, "all_cards.json"))
+	assert.True(t, os.IsNotExist(err))
+
+	collection, _, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 10, len(collection))
+
+	importer.BulkDataType = mtgdb.BulkDataOracleCards
+	err = importer.DownloadData()
+	assert.EqualError(t, err, "bulk data of type `oracle_cards` not found")
+}
+
+func TestImporterDownloadDataOnlyWhenUpdated(t *testing.T) {
+	defer os.RemoveAll(TEMP_DIR)
+	updatedAt := "2022-03-01T10:00:00.000+00:00"
+	downloads := make(map[string]int)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sets":
+			http.ServeFile(w, r, filepath.Join(FIXTURES_PATH, "data", "all_sets.json"))
+		case "/bulk-data":
+			fmt.Fprintf(w, `{"data":[{"type":"all_cards","download_uri":"%[1]s/bulk/all_cards.json","updated_at":"%[2]s"},{"type":"rulings","download_uri":"%[1]s/bulk/rulings.json","updated_at":"%[2]s"}]}`, server.URL, updatedAt)
+		default:
+			downloads[r.URL.Path]++
+			http.ServeFile(w, r, filepath.Join(FIXTURES_PATH, "data", filepath.Base(r.URL.Path)))
+		}
+	}))
+	defer server.Close()
+
+	importer := mtgdb.NewImporter(TEMP_DIR)
+	importer.ScryfallApiUrl = server.URL
+	err := importer.DownloadData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[string]int{"/bulk/all_cards.json": 1, "/bulk/rulings.json": 1}, downloads)
+	_, err = os.Stat(filepath.Join(TEMP_DIR, "bulk_data.json"))
+	assert.False(t, os.IsNotExist(err))
+
+	// Not updated on Scryfall
+	importer.ForceDownloadData = true
+	err = importer.DownloadData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[string]int{"/bulk/all_cards.json": 1, "/bulk/rulings.json": 1}, downloads)
+
+	// Updated on Scryfall
+	updatedAt = "2022-03-02T10:00:00.000+00:00"
+	err = importer.DownloadData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[string]int{"/bulk/all_cards.json": 2, "/bulk/rulings.json": 2}, downloads)
+
+	// Local file removed
+	err = os.Remove(filepath.Join(TEMP_DIR, "rulings.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	importer.ForceDownloadData = false
+	err = importer.DownloadData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[string]int{"/bulk/all_cards.json": 2, "/bulk/rulings.json": 3}, downloads)
+}
+
+func TestImporterDownloadDataRestart(t *testing.T) {
+	for _, name := range []string{"no validator", "wrong range", "not satisfiable", "other encoding"} {
+		os.RemoveAll(TEMP_DIR)
+		ranges := make([]string, 0)
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/sets":
+				http.ServeFile(w, r, filepath.Join(FIXTURES_PATH, "data", "all_sets.json"))
+			case "/bulk-data":
+				fmt.Fprintf(w, `{"data":[{"type":"all_cards","download_uri":"%[1]s/bulk/all_cards.json"},{"type":"rulings","download_uri":"%[1]s/bulk/rulings.json"}]}`, server.URL)
+			default:
+				body, err := ioutil.ReadFile(filepath.Join(FIXTURES_PATH, "data", filepath.Base(r.URL.Path)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				ranges = append(ranges, r.Header.Get("Range"))
+				if len(ranges) == 1 {
+					// Drop the connection at half body
+					if name != "no validator" {
+						w.Header().Set("ETag", `"v1"`)
+					}
+					w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+					w.Write(body[:len(body)/2])
+					w.(http.Flusher).Flush()
+					panic(http.ErrAbortHandler)
+				}
+				if r.Header.Get("Range") != "" {
+					var offset int
+					fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &offset)
+					switch name {
+					case "wrong range":
+						// A range of another version of the file
+						w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(body)-1, len(body)))
+					case "not satisfiable":
+						w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+						return
+					case "other encoding":
+						w.Header().Set("Content-Encoding", "gzip")
+						w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(body)-1, len(body)))
+						body = body[offset:]
+					}
+					w.WriteHeader(http.StatusPartialContent)
+				}
+				w.Write(body)
+			}
+		}))
+
+		importer := mtgdb.NewImporter(TEMP_DIR)
+		importer.ScryfallApiUrl = server.URL
+		importer.DownloadAssets = false
+		err := importer.DownloadData()
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name == "no validator" {
+			assert.Equal(t, []string{"", "", ""}, ranges, name)
+		} else {
+			assert.Equal(t, 4, len(ranges), name)
+			assert.Regexp(t, `^bytes=\d+-$`, ranges[1], name)
+			assert.Equal(t, "", ranges[2], name)
+		}
+		for _, fileName := range []string{"all_cards.json", "rulings.json"} {
+			expected, _ := ioutil.ReadFile(filepath.Join(FIXTURES_PATH, "data", fileName))
+			actual, _ := ioutil.ReadFile(filepath.Join(TEMP_DIR, fileName))
+			assert.Equal(t, expected, actual, name+" "+fileName)
+		}
+	}
+	os.RemoveAll(TEMP_DIR)
+}
+
+func TestImporterDownloadDataResumeAndCompress(t *testing.T) {
+	defer os.RemoveAll(TEMP_DIR)
+	ranges := make([]string, 0)
+	modTime := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sets":
+			http.ServeFile(w, r, filepath.Join(FIXTURES_PATH, "data", "all_sets.json"))
+		case "/bulk-data":
+			fmt.Fprintf(w, `{"data":[{"type":"all_cards","download_uri":"%[1]s/bulk/all_cards.json"},{"type":"rulings","download_uri":"%[1]s/bulk/rulings.json"}]}`, server.URL)
+		default:
+			assert.Equal(t, "gzip", r.Header.Get("Accept-Encoding"))
+			data, err := ioutil.ReadFile(filepath.Join(FIXTURES_PATH, "data", filepath.Base(r.URL.Path)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buffer bytes.Buffer
+			writer := gzip.NewWriter(&buffer)
+			writer.Write(data)
+			writer.Close()
+			body := buffer.Bytes()
+			w.Header().Set("Content-Encoding", "gzip")
+			if r.Header.Get("Range") == "" && len(ranges) == 0 {
+				// Drop the connection at half body
+				w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				w.Write(body[:len(body)/2])
+				w.(http.Flusher).Flush()
+				ranges = append(ranges, "")
+				panic(http.ErrAbortHandler)
+			}
+			ranges = append(ranges, r.Header.Get("Range"))
+			http.ServeContent(w, r, "", modTime, bytes.NewReader(body))
+		}
+	}))
+	defer server.Close()
+
+	importer := mtgdb.NewImporter(TEMP_DIR)
+	importer.ScryfallApiUrl = server.URL
+	importer.DownloadAssets = false
+	err := importer.DownloadData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// all_cards.json dropped and resumed, then rulings.json
+	assert.Equal(t, 3, len(ranges))
+	assert.Regexp(t, `^bytes=\d+-$`, ranges[1])
+	for _, fileName := range []string{"all_cards.json", "rulings.json"} {
+		expected, _ := ioutil.ReadFile(filepath.Join(FIXTURES_PATH, "data", fileName))
+		actual, _ := ioutil.ReadFile(filepath.Join(TEMP_DIR, fileName))
+		assert.Equal(t, expected, actual, fileName)
+		_, err = os.Stat(filepath.Join(TEMP_DIR, fileName+".part"))
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	// Keep files compressed
+	importer.CompressBulkData = true
+	err = importer.DownloadData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(filepath.Join(TEMP_DIR, "all_cards.json.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	_, err = gzip.NewReader(file)
+	assert.NoError(t, err)
+	collection, _, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 10, len(collection))
+}
+
+func TestDownloadFileIsAtomic(t *testing.T) {
+	defer os.RemoveAll(TEMP_DIR)
+	err := os.MkdirAll(TEMP_DIR, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/truncated.jpg" {
+			w.Header().Set("Content-Length", "1000")
+			w.Write(make([]byte, 500))
+			return
+		}
+		w.Write([]byte("new image"))
+	}))
+	defer server.Close()
+	file := filepath.Join(TEMP_DIR, "image.jpg")
+	err = ioutil.WriteFile(file, []byte("old image"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	importer := mtgdb.NewImporter(TEMP_DIR)
+	err = mtgdb.DownloadFile(importer, context.Background(), file, server.URL+"/truncated.jpg")
+	assert.Error(t, err)
+	content, _ := ioutil.ReadFile(file)
+	assert.Equal(t, "old image", string(content))
+
+	err = mtgdb.DownloadFile(importer, context.Background(), file, server.URL+"/image.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ = ioutil.ReadFile(file)
+	assert.Equal(t, "new image", string(content))
+
+	files, _ := ioutil.ReadDir(TEMP_DIR)
+	assert.Equal(t, 1, len(files))
+}
+
+func TestDownloadFileRetry(t *testing.T) {
+	defer os.RemoveAll(TEMP_DIR)
+	err := os.MkdirAll(TEMP_DIR, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		switch r.URL.Path {
+		case "/throttled.jpg":
+			if requests[r.URL.Path] == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.Write([]byte("image"))
+		case "/unavailable.jpg":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	file := filepath.Join(TEMP_DIR, "image.jpg")
+
+	importer := mtgdb.NewImporter(TEMP_DIR)
+	importer.RetryDelay = time.Millisecond
+	start := time.Now()
+	err = mtgdb.DownloadFile(importer, context.Background(), file, server.URL+"/throttled.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, time.Since(start) >= time.Second)
+	assert.Equal(t, 2, requests["/throttled.jpg"])
+
+	err = mtgdb.DownloadFile(importer, context.Background(), file, server.URL+"/unavailable.jpg")
+	var httpErr *mtgdb.HttpError
+	if assert.True(t, errors.As(err, &httpErr)) {
+		assert.Equal(t, http.StatusServiceUnavailable, httpErr.StatusCode)
+		assert.True(t, httpErr.Temporary())
+	}
+	assert.Equal(t, 3, requests["/unavailable.jpg"])
+
+	err = mtgdb.DownloadFile(importer, context.Background(), file, server.URL+"/missing.jpg")
+	if assert.True(t, errors.As(err, &httpErr)) {
+		assert.Equal(t, http.StatusNotFound, httpErr.StatusCode)
+		assert.False(t, httpErr.Temporary())
+	}
+	assert.Equal(t, 1, requests["/missing.jpg"])
+
+	// Without delay the retries do not wait
+	importer.RetryDelay = 0
+	start = time.Now()
+	err = mtgdb.DownloadFile(importer, context.Background(), file, server.URL+"/unavailable.jpg")
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, 6, requests["/unavailable.jpg"])
+
+	// The download is tried at least once
+	for _, attempts := range []int{0, -1} {
+		importer.RetryAttempts = attempts
+		requests["/unavailable.jpg"] = 0
+		err = mtgdb.DownloadFile(importer, context.Background(), file, server.URL+"/unavailable.jpg")
+		assert.Error(t, err)
+		assert.Equal(t, 1, requests["/unavailable.jpg"])
+	}
+}
+
+func TestImporterSetRateLimit(t *testing.T) {
+	defer os.RemoveAll(TEMP_DIR)
+	err := os.MkdirAll(TEMP_DIR, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("image"))
+	}))
+	defer server.Close()
+	file := filepath.Join(TEMP_DIR, "image.jpg")
+
+	importer := mtgdb.NewImporter(TEMP_DIR)
+	importer.SetRateLimit("127.0.0.1", 50*time.Millisecond, 1)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		err = mtgdb.DownloadFile(importer, context.Background(), file, server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+
+	importer.SetRateLimit("127.0.0.1", 0, 0)
+	start = time.Now()
+	for i := 0; i < 5; i++ {
+		err = mtgdb.DownloadFile(importer, context.Background(), file, server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.True(t, time.Since(start) < 200*time.Millisecond)
+}
+
+func TestImporterSetDownloadConcurrency(t *testing.T) {
+	defer os.RemoveAll(TEMP_DIR)
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		w.Write([]byte("image"))
+	}))
+	defer server.Close()
+
+	importer := mtgdb.NewImporter(filepath.Join(FIXTURES_PATH, "data"))
+	importer.ImagesDir = filepath.Join(TEMP_DIR, "images")
+	importer.DownloadOnlyEnAssets = false
+	importer.HttpClient = &http.Client{Transport: &scryfallTransport{server: server}}
+	importer.SetDownloadConcurrency(2)
+	_, report, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotZero(t, report.ImagesDownloaded)
+	assert.Equal(t, int32(2), maxInFlight)
+}
+
+func TestImporterStreamCardsFromJson(t *testing.T) {
+	importer := mtgdb.NewImporter(filepath.Join(FIXTURES_PATH, "data"))
+	importer.DownloadAssets = false
+	expected, expectedReport, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	streamed := make(map[string]*mtgdb.Card)
+	batches, updatesCount := 0, 0
+	report, err := importer.StreamCardsFromJson(3, func(cards, updates []mtgdb.Card, oracleCards []mtgdb.OracleCard) error {
+		batches++
+		updatesCount += len(updates)
+		assert.True(t, len(cards) <= 3)
+		for i := range cards {
+			streamed[cards[i].SetCode+"-"+cards[i].CollectorNumber] = &cards[i]
+		}
+		for _, update := range updates {
+			card, found := streamed[update.SetCode+"-"+update.CollectorNumber]
+			if !assert.True(t, found) {
+				continue
+			}
+			// Apply the not zero fields and add the translations as BulkUpdate
+			// does
+			for _, translation := range update.Translations {
+				if current := card.Translation(translation.Lang); current != nil {
+					*current = translation
+				} else {
+					card.Translations = append(card.Translations, translation)
+				}
+			}
+			sort.Slice(card.Translations, func(i, j int) bool {
+				return card.Translations[i].Lang < card.Translations[j].Lang
+			})
+			update.Translations = nil
+			updateValue := reflect.ValueOf(update)
+			cardValue := reflect.ValueOf(card).Elem()
+			for i := 0; i < updateValue.NumField(); i++ {
+				if !updateValue.Field(i).IsZero() {
+					cardValue.Field(i).Set(updateValue.Field(i))
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 4, batches)
+	assert.NotZero(t, updatesCount)
+	assert.Equal(t, expectedReport.CardsPerSet, report.CardsPerSet)
+	assert.Equal(t, expectedReport.Sets, report.Sets)
+	assert.Equal(t, len(expected), len(streamed))
+	for _, card := range expected {
+		assert.Equal(t, card, *streamed[card.SetCode+"-"+card.CollectorNumber])
+	}
+
+	// Flush errors stop the import
+	flushErr := errors.New("flush failed")
+	_, err = importer.StreamCardsFromJson(3, func(cards, updates []mtgdb.Card, oracleCards []mtgdb.OracleCard) error {
+		return flushErr
+	})
+	assert.Equal(t, flushErr, err)
+}
+
+func TestImporterBuildCardsFromJsonContextCancel(t *testing.T) {
+	defer os.RemoveAll(TEMP_DIR)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	requested := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Write half image then hang until the import is cancelled
+		w.Header().Set("Content-Length", "1000")
+		w.Write(make([]byte, 500))
+		w.(http.Flusher).Flush()
+		select {
+		case requested <- struct{}{}:
+		default:
+		}
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	go func() {
+		<-requested
+		cancel()
+	}()
+
+	importer := mtgdb.NewImporter(filepath.Join(FIXTURES_PATH, "data"))
+	importer.ImagesDir = filepath.Join(TEMP_DIR, "images")
+	importer.HttpClient = &http.Client{Transport: &scryfallTransport{server: server}}
+	collection, _, err := importer.BuildCardsFromJsonContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.Nil(t, collection)
+
+	images, err := filepath.Glob(filepath.Join(importer.ImagesDir, "cards", "*", "*.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, images)
+}
+
+// writeDataDir creates a data dir in TEMP_DIR with the fixture sets, no
+// rulings and allCardsJson as all_cards.json.
+func writeDataDir(t *testing.T, allCardsJson string) string {
+	dataDir := filepath.Join(TEMP_DIR, "data")
+	err := os.MkdirAll(dataDir, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allSetsJson, err := ioutil.ReadFile(filepath.Join(FIXTURES_PATH, "data", "all_sets.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{"all_sets.json": string(allSetsJson), "rulings.json": "[]", "all_cards.json": allCardsJson}
+	for name, content := range files {
+		err = ioutil.WriteFile(filepath.Join(dataDir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dataDir
+}
+
+func TestImporterBuildCardsFromJsonInvalidCard(t *testing.T) {
+	defer os.RemoveAll(TEMP_DIR)
+	dataDir := writeDataDir(t, `[
+		{"id": "valid-id", "name": "Acclaimed Contender", "lang": "en", "set": "eld", "collector_number": "1"},
+		{"id": "invalid-id", "name": "Acclaimed Contender", "lang": "en", "set": "eld", "collector_number": ""}
+	]`)
+
+	importer := mtgdb.NewImporter(dataDir)
+	importer.DownloadAssets = false
+	collection, _, err := importer.BuildCardsFromJson()
+	assert.Nil(t, collection)
+	assert.True(t, errors.Is(err, mtgdb.ErrInvalidCard))
+	var invalidCardErr *mtgdb.InvalidCardError
+	if assert.True(t, errors.As(err, &invalidCardErr)) {
+		assert.Equal(t, "invalid-id", invalidCardErr.ScryfallID)
+	}
+
+	importer.SkipInvalidCards = true
+	collection, report, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(collection))
+	assert.Equal(t, []string{"invalid-id"}, report.InvalidCards)
+}
+
+func TestImporterBuildCardsFromJsonMalformedJson(t *testing.T) {
+	defer os.RemoveAll(TEMP_DIR)
+	dataDir := writeDataDir(t, `[{"id": "valid-id", "name": "Acclaimed Contender", "lang": "en", "set": "eld", "collector_number": "1"}, {"id": `)
+
+	importer := mtgdb.NewImporter(dataDir)
+	importer.DownloadAssets = false
+	_, _, err := importer.BuildCardsFromJson()
+	assert.Error(t, err)
+}
+
 // func TestImporterDownloadData(t *testing.T) {
 // 	defer os.RemoveAll(TEMP_DIR)
 
@@ -37,7 +721,10 @@ func TestImporterBuildCardsFromJson(t *testing.T) {
 	importer.DownloadOnlyEnAssets = false
 	importer.ImagesDir = filepath.Join(TEMP_DIR, "images")
 
-	collection, downloadedImagesCount := importer.BuildCardsFromJson()
+	collection, report, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
 	sort.Slice(collection, func(i, j int) bool {
 		return collection[i].ScryfallID > collection[j].ScryfallID
 	})
@@ -50,7 +737,7 @@ func TestImporterBuildCardsFromJson(t *testing.T) {
 	}
 
 	assert.Equal(t, 10, len(collection))
-	assert.Equal(t, uint32(44), downloadedImagesCount)
+	assert.Equal(t, uint32(44), report.ImagesDownloaded)
 
 	// Acclaimed Contender
 	///////////////////////
@@ -190,7 +877,7 @@ func TestImporterBuildCardsFromJson(t *testing.T) {
 	// IDs
 	assert.Equal(t, "dae8751c-4c72-4034-a192-a1e166f20246", card.ScryfallID)
 	// Files
-	_, err := os.Stat(filepath.Join(importer.ImagesDir, "/cards/eld/eld_334_en.jpg"))
+	_, err = os.Stat(filepath.Join(importer.ImagesDir, "/cards/eld/eld_334_en.jpg"))
 	assert.False(t, os.IsNotExist(err))
 	_, err = os.Stat(filepath.Join(importer.ImagesDir, "/cards/eld/eld_334_en_back.jpg"))
 	assert.True(t, os.IsNotExist(err))
@@ -750,23 +1437,27 @@ func TestImporterBuildCardsFromJsonDownloadOnlyEnAssets(t *testing.T) {
 	importer.DownloadAssets = true
 	importer.ImagesDir = filepath.Join(TEMP_DIR, "images")
 
-	collection, downloadedImagesCount := importer.BuildCardsFromJson()
+	collection, report, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
 	sort.Slice(collection, func(i, j int) bool {
 		return collection[i].ScryfallID > collection[j].ScryfallID
 	})
 
-	assert.Equal(t, uint32(13), downloadedImagesCount)
+	assert.Equal(t, uint32(13), report.ImagesDownloaded)
 	// Index 7 is Nissa Japan
 	card := collection[7]
 	assert.Equal(t, "Nissa, Who Shakes the World", card.EnName)
 	assert.Equal(t, "世界を揺るがす者、ニッサ", card.JaName)
-	_, err := os.Stat(filepath.Join(importer.ImagesDir, "/cards/war/war_169★_en.jpg"))
+	_, err = os.Stat(filepath.Join(importer.ImagesDir, "/cards/war/war_169★_en.jpg"))
 	assert.False(t, os.IsNotExist(err))
 	_, err = os.Stat(filepath.Join(importer.ImagesDir, "/cards/war/war_169★_ja.jpg"))
 	assert.True(t, os.IsNotExist(err))
 }
 
-func TestBulkInsert(t *testing.T) {
+// openTestDB opens the test database, migrates it and removes all its rows.
+func openTestDB(t *testing.T) *gorm.DB {
 	dbConnection := os.Getenv("DB_CONNECTION")
 	if dbConnection == "" {
 		dbConnection = "root@tcp(127.0.0.1:3306)/mtgdb_test?charset=utf8mb4&parseTime=True"
@@ -780,6 +1471,66 @@ func TestBulkInsert(t *testing.T) {
 		db.Config.Logger = db.Config.Logger.LogMode(logger.Info)
 	}
 	mtgdb.AutoMigrate(db)
+	models := []interface{}{&mtgdb.CardFace{}, &mtgdb.CardPrice{}, &mtgdb.CardRelation{}, &mtgdb.CardTranslation{}, &mtgdb.OracleCard{}, &mtgdb.Card{}, &mtgdb.Set{}}
+	for _, model := range models {
+		err = db.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(model).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestEachIdsChunk(t *testing.T) {
+	ids := make([]uint, 2500)
+	for i := range ids {
+		ids[i] = uint(i)
+	}
+	chunks := make([][]uint, 0)
+	err := mtgdb.EachIdsChunk(ids, func(chunk []uint) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	assert.Nil(t, err)
+	if assert.Equal(t, 3, len(chunks)) {
+		assert.Equal(t, ids[:1000], chunks[0])
+		assert.Equal(t, ids[1000:2000], chunks[1])
+		assert.Equal(t, ids[2000:], chunks[2])
+	}
+
+	err = mtgdb.EachIdsChunk(ids, func(chunk []uint) error {
+		return errors.New("stop")
+	})
+	assert.EqualError(t, err, "stop")
+	err = mtgdb.EachIdsChunk(nil, func(chunk []uint) error {
+		return errors.New("not called")
+	})
+	assert.Nil(t, err)
+}
+
+func TestEachCardKeysChunk(t *testing.T) {
+	cards := make([]mtgdb.Card, 1500)
+	for i := range cards {
+		cards[i] = mtgdb.Card{SetCode: "eld", CollectorNumber: strconv.Itoa(i)}
+	}
+	updates := []mtgdb.Card{{SetCode: "eld", CollectorNumber: "1"}, {SetCode: "isd", CollectorNumber: "1"}}
+	chunks := make([][][]interface{}, 0)
+	err := mtgdb.EachCardKeysChunk(func(keys [][]interface{}) error {
+		chunks = append(chunks, keys)
+		return nil
+	}, cards, updates)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(chunks)) {
+		assert.Equal(t, 1000, len(chunks[0]))
+		assert.Equal(t, []interface{}{"eld", "0"}, chunks[0][0])
+		// Duplicated keys are skipped
+		assert.Equal(t, 501, len(chunks[1]))
+		assert.Equal(t, []interface{}{"isd", "1"}, chunks[1][500])
+	}
+}
+
+func TestBulkInsert(t *testing.T) {
+	db := openTestDB(t)
 
 	cards := []mtgdb.Card{
 		{
@@ -818,7 +1569,7 @@ func TestBulkInsert(t *testing.T) {
 		},
 	}
 
-	err = mtgdb.BulkInsert(db, cards)
+	err := mtgdb.BulkInsert(db, cards)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -854,8 +1605,10 @@ func TestDownloadFile(t *testing.T) {
 	defer os.RemoveAll(TEMP_DIR)
 	url := "https://cards.scryfall.io/normal/front/5/d/5d10b752-d9cb-419d-a5c4-d4ee1acb655e.jpg?1562736365"
 
+	importer := mtgdb.NewImporter(TEMP_DIR)
+
 	// Test download file
-	err = mtgdb.DownloadFile(file, url)
+	err = mtgdb.DownloadFile(importer, context.Background(), file, url)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -866,7 +1619,7 @@ func TestDownloadFile(t *testing.T) {
 	assert.False(t, os.IsNotExist(err))
 
 	// Test download file with different SHA1
-	downloaded, err := mtgdb.DownloadFileWhenChanged(file, url, nil, "differentSHA1")
+	reason, err := mtgdb.DownloadFileWhenChanged(importer, context.Background(), file, url, nil, "differentSHA1")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -876,11 +1629,11 @@ func TestDownloadFile(t *testing.T) {
 		t.Fatal(err)
 	}
 	assert.True(t, currentFileTime.Before(stat.ModTime()))
-	assert.True(t, downloaded)
+	assert.NotEmpty(t, reason)
 
 	// Skip this test, Scryfall images no longer have SHA1 in the header
 	// // Test download file with same SHA1
-	// downloaded, err = mtgdb.DownloadFileWhenChanged(file, url, nil, "8b2ee43e87867e87a8fca7bfff0c7498f1d1fea8")
+	// reason, err = mtgdb.DownloadFileWhenChanged(importer, context.Background(), file, url, nil, "8b2ee43e87867e87a8fca7bfff0c7498f1d1fea8")
 	// if err != nil {
 	// 	t.Fatal(err)
 	// }
@@ -890,7 +1643,7 @@ func TestDownloadFile(t *testing.T) {
 	// 	t.Fatal(err)
 	// }
 	// assert.True(t, currentFileTime.Equal(stat.ModTime()))
-	// assert.False(t, downloaded)
+	// assert.Empty(t, reason)
 
 	// Test download file with older time
 	olderTime, _ := time.Parse(time.RFC3339, "1990-01-01T00:00:00.00Z")
@@ -902,7 +1655,7 @@ func TestDownloadFile(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	downloaded, err = mtgdb.DownloadFileWhenChanged(file, url, stat, "")
+	reason, err = mtgdb.DownloadFileWhenChanged(importer, context.Background(), file, url, stat, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -911,7 +1664,7 @@ func TestDownloadFile(t *testing.T) {
 		t.Fatal(err)
 	}
 	assert.False(t, olderTime.Equal(stat.ModTime()))
-	assert.True(t, downloaded)
+	assert.NotEmpty(t, reason)
 
 	// Test download file with newer time
 	newerTime, _ := time.Parse(time.RFC3339, "2120-06-01T00:00:00.00Z")
@@ -923,7 +1676,7 @@ func TestDownloadFile(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	downloaded, err = mtgdb.DownloadFileWhenChanged(file, url, stat, "")
+	reason, err = mtgdb.DownloadFileWhenChanged(importer, context.Background(), file, url, stat, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -932,5 +1685,5 @@ func TestDownloadFile(t *testing.T) {
 		panic(err)
 	}
 	assert.True(t, newerTime.Equal(stat.ModTime()))
-	assert.False(t, downloaded)
+	assert.Empty(t, reason)
 }
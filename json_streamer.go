@@ -1,8 +1,11 @@
 package mtgdb
 
 import (
+	"bufio"
+	"compress/gzip"
 	"encoding/json"
 	"errors"
+	"io"
 	"os"
 )
 
@@ -10,41 +13,87 @@ type Token interface{}
 
 type JsonStreamer struct {
 	*json.Decoder
-	file *os.File
+	file io.ReadCloser
+	err  error
 }
 
+// NewJsonStreamer opens the JSON array in the file at filepath. The file can
+// also be gzip compressed.
 func NewJsonStreamer(filepath string) (*JsonStreamer, error) {
-	file, err := os.Open(filepath)
+	file, err := openDataFile(filepath)
 	if err != nil {
 		return nil, err
 	}
 	decoder := json.NewDecoder(file)
 	token, err := decoder.Token()
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
 	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		file.Close()
 		return nil, errors.New("json is not an array")
 	}
 	return &JsonStreamer{Decoder: decoder, file: file}, nil
 }
 
+// Next reports whether there is another element to Get. When it returns false
+// the file is closed and Err returns the error, if any, that stopped the
+// streaming.
 func (streamer *JsonStreamer) Next() bool {
+	if streamer.err != nil {
+		return false
+	}
 	more := streamer.Decoder.More()
 	if !more {
-		_, err := streamer.Token()
-		if err != nil {
-			panic(err)
-		}
-		streamer.file.Close()
+		_, streamer.err = streamer.Token()
+		streamer.Close()
 	}
 	return more
 }
 
+func (streamer *JsonStreamer) Err() error {
+	return streamer.err
+}
+
+func (streamer *JsonStreamer) Close() error {
+	return streamer.file.Close()
+}
+
 func (streamer *JsonStreamer) Get(out interface{}) error {
 	err := streamer.Decode(out)
 	if err != nil {
+		streamer.err = err
 		return err
 	}
 	return nil
 }
+
+// openDataFile opens the file at filePath decompressing it on the fly if it is
+// gzip compressed.
+func openDataFile(filePath string) (io.ReadCloser, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(file)
+	magic, _ := reader.Peek(2)
+	if len(magic) < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		return &dataFile{Reader: reader, file: file}, nil
+	}
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &dataFile{Reader: gzipReader, file: file}, nil
+}
+
+type dataFile struct {
+	io.Reader
+	file *os.File
+}
+
+func (dataFile *dataFile) Close() error {
+	return dataFile.file.Close()
+}
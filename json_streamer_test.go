@@ -1,6 +1,8 @@
 package mtgdb_test
 
 import (
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -63,3 +65,28 @@ func TestJsonStreamerObject(t *testing.T) {
 	_, err := mtgdb.NewJsonStreamer(filepath.Join("./fixtures", "data", "stream_me.json"))
 	assert.Error(t, err, "json is not an array")
 }
+
+func TestJsonStreamerTruncated(t *testing.T) {
+	filePath := filepath.Join(os.TempDir(), "mtgdb_truncated.json")
+	defer os.Remove(filePath)
+	err := ioutil.WriteFile(filePath, []byte(`[{"name": "Acclaimed Contender"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamer, err := mtgdb.NewJsonStreamer(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cardJson cardJsonStruct
+	count := 0
+	for streamer.Next() {
+		err := streamer.Get(&cardJson)
+		if err != nil {
+			break
+		}
+		count++
+	}
+	assert.Equal(t, 1, count)
+	assert.False(t, streamer.Next())
+	assert.Error(t, streamer.Err())
+}
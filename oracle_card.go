@@ -0,0 +1,151 @@
+package mtgdb
+
+import (
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OracleCard holds the data shared by all the printings of a card, identified
+// by its Scryfall oracle ID. Cards keep their copy of these fields for
+// compatibility.
+type OracleCard struct {
+	ID uint `gorm:"primary_key"`
+
+	OracleID string `gorm:"size:255;not null;uniqueIndex"`
+	Name     string `gorm:"size:255;not null;index"`
+
+	CMC           float32
+	ColorIdentity SliceString `gorm:"type:json"`
+	Colors        SliceString `gorm:"type:json"`
+	Keywords      SliceString `gorm:"type:json"`
+	Layout        string      `gorm:"size:255"`
+	Legalities    MapString   `gorm:"type:json"`
+	Loyalty       string      `gorm:"size:255"`
+	ManaCost      string      `gorm:"size:255"`
+	OracleText    string
+	Power         string `gorm:"size:255"`
+	Reserved      bool
+	Toughness     string `gorm:"size:255"`
+	TypeLine      string `gorm:"size:255"`
+
+	Rulings Rulings `gorm:"type:json"`
+
+	// Printings of the card. The foreign key constraint is not created because
+	// cards can be stored before their oracle card.
+	Cards []Card `gorm:"foreignKey:OracleID;references:OracleID;constraint:-"`
+}
+
+func newOracleCard(card *Card) *OracleCard {
+	return &OracleCard{
+		OracleID:      card.OracleID,
+		Name:          card.EnName,
+		CMC:           card.CMC,
+		ColorIdentity: card.ColorIdentity,
+		Colors:        card.Colors,
+		Keywords:      card.Keywords,
+		Layout:        card.Layout,
+		Legalities:    card.Legalities,
+		Loyalty:       card.Loyalty,
+		ManaCost:      card.ManaCost,
+		OracleText:    card.OracleText,
+		Power:         card.Power,
+		Reserved:      card.Reserved,
+		Toughness:     card.Toughness,
+		TypeLine:      card.TypeLine,
+		Rulings:       card.Rulings,
+	}
+}
+
+// oraclePrinting is the printing an oracle card is built from.
+type oraclePrinting struct {
+	releasedAt time.Time
+	key        string
+}
+
+func newOraclePrinting(card *Card) oraclePrinting {
+	printing := oraclePrinting{key: card.SetCode + "-" + card.CollectorNumber}
+	if card.ReleasedAt != nil {
+		printing.releasedAt = *card.ReleasedAt
+	}
+	return printing
+}
+
+// newerThan reports whether printing is preferred to other: the latest
+// printing wins, so that the oracle card includes the last errata.
+func (printing oraclePrinting) newerThan(other oraclePrinting) bool {
+	if !printing.releasedAt.Equal(other.releasedAt) {
+		return printing.releasedAt.After(other.releasedAt)
+	}
+	return printing.key < other.key
+}
+
+// buildOracleCards returns the oracle cards of cards, sorted by oracle ID.
+// Each oracle card is built from the newest printing, skipping the oracle
+// cards already built from a newer printing in printings, which is updated.
+func buildOracleCards(cards []*Card, printings map[string]oraclePrinting) []OracleCard {
+	oracleCollection := make(map[string]*OracleCard)
+	for _, card := range cards {
+		if card.OracleID == "" {
+			continue
+		}
+		printing := newOraclePrinting(card)
+		if current, found := printings[card.OracleID]; found && !printing.newerThan(current) {
+			continue
+		}
+		printings[card.OracleID] = printing
+		oracleCollection[card.OracleID] = newOracleCard(card)
+	}
+	oracleCards := make([]OracleCard, 0, len(oracleCollection))
+	for _, oracleCard := range oracleCollection {
+		oracleCards = append(oracleCards, *oracleCard)
+	}
+	sort.Slice(oracleCards, func(i, j int) bool {
+		return oracleCards[i].OracleID < oracleCards[j].OracleID
+	})
+	return oracleCards
+}
+
+// OracleCards returns the oracle cards of the cards built by the last
+// BuildCardsFromJson, sorted by oracle ID. StreamCardsFromJson passes the
+// oracle cards to its CardsBatchFunc instead.
+func (importer *Importer) OracleCards() []OracleCard {
+	cards := make([]*Card, 0, len(importer.cardCollection))
+	for _, card := range importer.cardCollection {
+		cards = append(cards, card)
+	}
+	return buildOracleCards(cards, make(map[string]oraclePrinting))
+}
+
+// BulkInsertOracleCards inserts oracleCards into db, updating the ones with
+// the same oracle ID.
+func BulkInsertOracleCards(db *gorm.DB, oracleCards []OracleCard) error {
+	if len(oracleCards) == 0 {
+		return nil
+	}
+	scope := db.Clauses(clause.OnConflict{UpdateAll: true}).Session(&gorm.Session{CreateBatchSize: 500})
+	return scope.Omit("Cards").Create(oracleCards).Error
+}
+
+// FindOracleCard returns the oracle card of card.
+func (card *Card) FindOracleCard(db *gorm.DB) (*OracleCard, error) {
+	oracleCard := &OracleCard{}
+	err := db.Where("oracle_id = ?", card.OracleID).First(oracleCard).Error
+	if err != nil {
+		return nil, err
+	}
+	return oracleCard, nil
+}
+
+// Printings returns all the printings of card, card included, ordered by
+// release date.
+func (card *Card) Printings(db *gorm.DB) ([]Card, error) {
+	printings := make([]Card, 0)
+	if card.OracleID == "" {
+		return append(printings, *card), nil
+	}
+	err := db.Where("oracle_id = ?", card.OracleID).Order("released_at, set_code, collector_number").Find(&printings).Error
+	return printings, err
+}
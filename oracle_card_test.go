@@ -0,0 +1,79 @@
+package mtgdb_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/pioz/mtgdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImporterOracleCards(t *testing.T) {
+	importer := mtgdb.NewImporter(filepath.Join(FIXTURES_PATH, "data"))
+	importer.DownloadAssets = false
+	collection, _, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oracleCards := importer.OracleCards()
+	printings := make(map[string][]mtgdb.Card)
+	for _, card := range collection {
+		if card.OracleID != "" {
+			printings[card.OracleID] = append(printings[card.OracleID], card)
+		}
+	}
+	assert.Equal(t, len(printings), len(oracleCards))
+	for i, oracleCard := range oracleCards {
+		if i > 0 {
+			assert.True(t, oracleCards[i-1].OracleID < oracleCard.OracleID)
+		}
+		for _, card := range printings[oracleCard.OracleID] {
+			assert.Equal(t, card.EnName, oracleCard.Name)
+			assert.Equal(t, card.OracleText, oracleCard.OracleText)
+			assert.Equal(t, card.Legalities, oracleCard.Legalities)
+			assert.Equal(t, card.Rulings, oracleCard.Rulings)
+		}
+	}
+
+	// Printings of the same card share the oracle card
+	assert.Equal(t, 4, len(printings["35df179a-c0e6-4ac1-a861-e6e9b4d1614d"]))
+
+	// Streaming passes the same oracle cards in batches
+	streamedOracleCards := make(map[string]mtgdb.OracleCard)
+	_, err = importer.StreamCardsFromJson(1, func(cards, updates []mtgdb.Card, oracleCards []mtgdb.OracleCard) error {
+		for _, oracleCard := range oracleCards {
+			streamedOracleCards[oracleCard.OracleID] = oracleCard
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(oracleCards), len(streamedOracleCards))
+	for _, oracleCard := range oracleCards {
+		assert.Equal(t, oracleCard, streamedOracleCards[oracleCard.OracleID])
+	}
+}
+
+func TestImporterOracleCardsOfChangedCards(t *testing.T) {
+	importer := mtgdb.NewImporter(filepath.Join(FIXTURES_PATH, "data"))
+	importer.DownloadAssets = false
+	importer.ExcludeNotEnCards = true
+	importer.Hooks = []mtgdb.ImportHook{mtgdb.ImportHookFuncs{
+		Card: func(cardJson *mtgdb.ScryfallCard, card *mtgdb.Card) error {
+			card.OracleText = "Changed by hook"
+			return nil
+		},
+	}}
+	_, _, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oracleCards := importer.OracleCards()
+	assert.NotEmpty(t, oracleCards)
+	for _, oracleCard := range oracleCards {
+		assert.Equal(t, "Changed by hook", oracleCard.OracleText)
+		// war-169★ has not an english printing
+		assert.NotEqual(t, "5aa3abf1-d56b-4f42-8c84-7e5a2c15ee0f", oracleCard.OracleID)
+	}
+}
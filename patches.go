@@ -0,0 +1,141 @@
+package mtgdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CardPatchesFileNames are the files, inside the data dir, where patches to
+// correct the Scryfall data can be declared. The first file found is used.
+var CardPatchesFileNames = []string{"card_patches.json", "card_patches.yml", "card_patches.yaml"}
+
+// CardPatch overrides some fields of the card with ScryfallID, or with SetCode
+// and CollectorNumber. Fields keys are the names of the Card fields (es:
+// EnName).
+type CardPatch struct {
+	ScryfallID      string                 `json:"scryfall_id" yaml:"scryfall_id"`
+	SetCode         string                 `json:"set_code" yaml:"set_code"`
+	CollectorNumber string                 `json:"collector_number" yaml:"collector_number"`
+	Fields          map[string]interface{} `json:"fields" yaml:"fields"`
+}
+
+func (patch *CardPatch) String() string {
+	if patch.ScryfallID != "" {
+		return patch.ScryfallID
+	}
+	return fmt.Sprintf("%s-%s", patch.SetCode, patch.CollectorNumber)
+}
+
+// cardPatches are the loaded patches indexed by Scryfall ID and by set code
+// and collector number.
+type cardPatches struct {
+	patches      []CardPatch
+	fields       [][]byte
+	byScryfallID map[string][]int
+	byKey        map[string][]int
+	matched      []bool
+}
+
+func (importer *Importer) loadCardPatches() (*cardPatches, error) {
+	patches := &cardPatches{byScryfallID: make(map[string][]int), byKey: make(map[string][]int)}
+	for _, fileName := range CardPatchesFileNames {
+		filePath := filepath.Join(importer.DataDir, fileName)
+		data, err := ioutil.ReadFile(filePath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(fileName, ".json") {
+			err = json.Unmarshal(data, &patches.patches)
+		} else {
+			err = yaml.Unmarshal(data, &patches.patches)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("card patches file `%s` is not valid: %w", filePath, err)
+		}
+		break
+	}
+	for i, patch := range patches.patches {
+		if patch.ScryfallID == "" && (patch.SetCode == "" || patch.CollectorNumber == "") {
+			return nil, fmt.Errorf("card patch %d has no Scryfall ID nor set code and collector number", i)
+		}
+		fields, err := json.Marshal(patch.Fields)
+		if err != nil {
+			return nil, fmt.Errorf("card patch `%s` is not valid: %w", patch.String(), err)
+		}
+		// Check that all fields exist in Card and have the right type
+		decoder := json.NewDecoder(bytes.NewReader(fields))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&Card{})
+		if err != nil {
+			return nil, fmt.Errorf("card patch `%s` is not valid: %w", patch.String(), err)
+		}
+		patches.fields = append(patches.fields, fields)
+		if patch.ScryfallID != "" {
+			patches.byScryfallID[patch.ScryfallID] = append(patches.byScryfallID[patch.ScryfallID], i)
+		} else {
+			key := fmt.Sprintf("%s-%s", patch.SetCode, patch.CollectorNumber)
+			patches.byKey[key] = append(patches.byKey[key], i)
+		}
+	}
+	patches.matched = make([]bool, len(patches.patches))
+	return patches, nil
+}
+
+// apply overrides the fields of card with the patches that match it.
+func (patches *cardPatches) apply(card *Card) error {
+	if patches == nil || len(patches.patches) == 0 {
+		return nil
+	}
+	for _, i := range patches.matching(card.ScryfallID, card.SetCode, card.CollectorNumber) {
+		err := json.Unmarshal(patches.fields[i], card)
+		if err != nil {
+			return err
+		}
+		patches.matched[i] = true
+	}
+	return nil
+}
+
+// skip marks as matched the patches of a card row not imported, like a card
+// of a set not selected or filtered out: they are not stale.
+func (patches *cardPatches) skip(scryfallID, setCode, collectorNumber string) {
+	if patches == nil || len(patches.patches) == 0 {
+		return
+	}
+	for _, i := range patches.matching(scryfallID, setCode, collectorNumber) {
+		patches.matched[i] = true
+	}
+}
+
+func (patches *cardPatches) matching(scryfallID, setCode, collectorNumber string) []int {
+	indexes := make([]int, 0)
+	indexes = append(indexes, patches.byKey[fmt.Sprintf("%s-%s", setCode, collectorNumber)]...)
+	if scryfallID != "" {
+		indexes = append(indexes, patches.byScryfallID[scryfallID]...)
+	}
+	return indexes
+}
+
+// stale returns the patches that did not match any card row.
+func (patches *cardPatches) stale() []string {
+	stale := make([]string, 0)
+	if patches == nil {
+		return stale
+	}
+	for i, patch := range patches.patches {
+		if !patches.matched[i] {
+			stale = append(stale, patch.String())
+		}
+	}
+	return stale
+}
@@ -0,0 +1,86 @@
+package mtgdb_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pioz/mtgdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImporterCardPatches(t *testing.T) {
+	defer os.RemoveAll(TEMP_DIR)
+	allCardsJson, err := ioutil.ReadFile(filepath.Join(FIXTURES_PATH, "data", "all_cards.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataDir := writeDataDir(t, string(allCardsJson))
+	err = ioutil.WriteFile(filepath.Join(dataDir, "card_patches.yml"), []byte(`
+- scryfall_id: fb6b12e7-bb93-4eb6-bad1-b256a6ccff4e
+  fields:
+    EnName: Acclaimed Contender (fixed)
+    Rarity: mythic
+- set_code: isd
+  collector_number: "176"
+  fields:
+    ItName: Ranger dell'Alba (fixed)
+- scryfall_id: not-found
+  fields:
+    EnName: Stale
+- set_code: war
+  collector_number: "999"
+  fields:
+    EnName: Stale
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	importer := mtgdb.NewImporter(dataDir)
+	importer.DownloadAssets = false
+	collection, report, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, card := range collection {
+		switch card.SetCode + "-" + card.CollectorNumber {
+		case "eld-1":
+			assert.Equal(t, "Acclaimed Contender (fixed)", card.EnName)
+			assert.Equal(t, "mythic", card.Rarity)
+			assert.Equal(t, "Contendiente aclamada", card.EsName)
+		case "isd-176":
+			assert.Equal(t, "Ranger dell'Alba (fixed)", card.ItName)
+			assert.Equal(t, "Daybreak Ranger // Nightfall Predator", card.EnName)
+		default:
+			assert.NotContains(t, card.EnName, "fixed")
+		}
+	}
+	assert.Equal(t, []string{"not-found", "war-999"}, report.StalePatches)
+
+	// Patches of cards of sets not imported or filtered out are not stale
+	importer.OnlyTheseSetCodes = []string{"isd"}
+	_, report, err = importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"not-found", "war-999"}, report.StalePatches)
+	importer.OnlyTheseSetCodes = []string{}
+	importer.CardFilters = []mtgdb.CardFilter{func(card *mtgdb.Card) bool {
+		return card.SetCode != "eld" && card.SetCode != "isd"
+	}}
+	_, report, err = importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"not-found", "war-999"}, report.StalePatches)
+	importer.CardFilters = []mtgdb.CardFilter{}
+
+	err = ioutil.WriteFile(filepath.Join(dataDir, "card_patches.json"), []byte(`[{"set_code": "eld", "collector_number": "1", "fields": {"Unknown": 1}}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = importer.BuildCardsFromJson()
+	assert.EqualError(t, err, `card patch `+"`eld-1`"+` is not valid: json: unknown field "Unknown"`)
+}
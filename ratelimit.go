@@ -0,0 +1,51 @@
+package mtgdb
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket: a token is added every interval up to burst
+// tokens and each request consumes a token.
+type rateLimiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+}
+
+func newRateLimiter(interval time.Duration, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{interval: interval, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Wait blocks until a token is available or ctx is done.
+func (limiter *rateLimiter) Wait(ctx context.Context) error {
+	limiter.mutex.Lock()
+	now := time.Now()
+	limiter.tokens += float64(now.Sub(limiter.last)) / float64(limiter.interval)
+	if limiter.tokens > limiter.burst {
+		limiter.tokens = limiter.burst
+	}
+	limiter.last = now
+	// Reserve the token, going in debt if it is not available yet
+	limiter.tokens--
+	wait := time.Duration(-limiter.tokens * float64(limiter.interval))
+	limiter.mutex.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
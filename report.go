@@ -0,0 +1,99 @@
+package mtgdb
+
+import (
+	"sort"
+	"time"
+)
+
+// ImportReport summarizes what happened during an import. It can be
+// serialized to JSON.
+type ImportReport struct {
+	Sets               []string            `json:"sets"`
+	CardsPerSet        map[string]int      `json:"cards_per_set"`
+	ImagesDownloaded   uint32              `json:"images_downloaded"`
+	ImagesSkipped      uint32              `json:"images_skipped"`
+	ImagesRedownloaded []RedownloadedImage `json:"images_redownloaded"`
+	FailedDownloads    []FailedDownload    `json:"failed_downloads"`
+	InvalidCards       []string            `json:"invalid_cards"`
+	FilteredCards      int                 `json:"filtered_cards"`
+	StalePatches       []string            `json:"stale_patches"`
+	Phases             []ImportPhase       `json:"phases"`
+	Delta              DeltaReport         `json:"delta"`
+}
+
+// DeltaReport lists the new sets and, by Scryfall ID, the cards written in
+// the database.
+type DeltaReport struct {
+	NewSets       []string            `json:"new_sets"`
+	Inserted      []string            `json:"inserted"`
+	Updated       []string            `json:"updated"`
+	ChangedFields map[string][]string `json:"changed_fields"`
+	Deleted       []string            `json:"deleted"`
+	Unchanged     int                 `json:"unchanged"`
+}
+
+// RedownloadedImage is an image already present on disk that has been
+// downloaded again. ImagesDownloaded counts also these images.
+type RedownloadedImage struct {
+	FilePath string `json:"file_path"`
+	Url      string `json:"url"`
+	Reason   string `json:"reason"`
+}
+
+type FailedDownload struct {
+	FilePath string `json:"file_path"`
+	Url      string `json:"url"`
+	Error    string `json:"error"`
+}
+
+type ImportPhase struct {
+	Name    string        `json:"name"`
+	Elapsed time.Duration `json:"elapsed_ns"`
+}
+
+// startPhase starts to time the phase called name. The returned function
+// stops the timer and adds the phase to the report.
+func (report *ImportReport) startPhase(name string) func() {
+	start := time.Now()
+	return func() {
+		report.Phases = append(report.Phases, ImportPhase{Name: name, Elapsed: time.Since(start)})
+	}
+}
+
+// Add adds the cards of delta to the report.
+func (report *DeltaReport) Add(delta CardsDelta) {
+	report.NewSets = append(report.NewSets, delta.NewSets...)
+	for _, card := range delta.Insert {
+		report.Inserted = append(report.Inserted, card.ScryfallID)
+	}
+	for _, card := range delta.Update {
+		report.Updated = append(report.Updated, card.ScryfallID)
+	}
+	for _, card := range delta.Patch {
+		if card.ScryfallID != "" {
+			report.Updated = append(report.Updated, card.ScryfallID)
+		}
+	}
+	if report.ChangedFields == nil {
+		report.ChangedFields = make(map[string][]string)
+	}
+	for scryfallID, fields := range delta.ChangedFields {
+		report.ChangedFields[scryfallID] = fields
+	}
+	report.Unchanged += delta.Unchanged
+}
+
+func (report *ImportReport) fillSets(sets map[string]*Set) {
+	report.Sets = make([]string, 0, len(sets))
+	for code := range sets {
+		report.Sets = append(report.Sets, code)
+	}
+	sort.Strings(report.Sets)
+}
+
+// countCards adds cards to the count of cards per set.
+func (report *ImportReport) countCards(cards map[string]*Card) {
+	for _, card := range cards {
+		report.CardsPerSet[card.SetCode]++
+	}
+}
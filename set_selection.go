@@ -0,0 +1,106 @@
+package mtgdb
+
+import "time"
+
+// selectSets returns the codes of the sets in setsJson to import, or nil if
+// all sets, and the cards of sets not listed, have to be imported. A set is
+// selected when it satisfies all the set selectors of the importer:
+// OnlyTheseSetCodes (and their child sets if IncludeChildSets is true),
+// OnlyTheseSetTypes, ExcludeTheseSetCodes, SetsReleasedAfter,
+// SetsReleasedBefore, OnlyDigitalSets and OnlyPaperSets.
+func (importer *Importer) selectSets(setsJson []setJsonStruct) map[string]struct{} {
+	if !importer.hasSetSelectors() {
+		return nil
+	}
+	codes := importer.setCodesWithChildren(setsJson)
+	selected := make(map[string]struct{})
+	for i := range setsJson {
+		setJson := &setsJson[i]
+		if codes != nil {
+			if _, found := codes[setJson.Code]; !found {
+				continue
+			}
+		}
+		if importer.isSetSelected(setJson) {
+			selected[setJson.Code] = struct{}{}
+		}
+	}
+	return selected
+}
+
+func (importer *Importer) isSetCodeSelected(code string) bool {
+	if importer.selectedSets == nil {
+		return true
+	}
+	_, found := importer.selectedSets[code]
+	return found
+}
+
+func (importer *Importer) hasSetSelectors() bool {
+	return len(importer.OnlyTheseSetCodes) != 0 ||
+		len(importer.OnlyTheseSetTypes) != 0 ||
+		len(importer.ExcludeTheseSetCodes) != 0 ||
+		!importer.SetsReleasedAfter.IsZero() ||
+		!importer.SetsReleasedBefore.IsZero() ||
+		importer.OnlyDigitalSets ||
+		importer.OnlyPaperSets
+}
+
+// setCodesWithChildren returns importer.OnlyTheseSetCodes and, if
+// importer.IncludeChildSets is true, the codes of their descendant sets. It
+// returns nil if there are no codes to select.
+func (importer *Importer) setCodesWithChildren(setsJson []setJsonStruct) map[string]struct{} {
+	if len(importer.OnlyTheseSetCodes) == 0 {
+		return nil
+	}
+	codes := make(map[string]struct{})
+	for _, code := range importer.OnlyTheseSetCodes {
+		codes[code] = struct{}{}
+	}
+	if !importer.IncludeChildSets {
+		return codes
+	}
+	// Add the children until no new set is found, so that also the children
+	// of the children are selected
+	for added := true; added; {
+		added = false
+		for _, setJson := range setsJson {
+			if _, found := codes[setJson.Code]; found || setJson.ParentSetCode == "" {
+				continue
+			}
+			if _, found := codes[setJson.ParentSetCode]; found {
+				codes[setJson.Code] = struct{}{}
+				added = true
+			}
+		}
+	}
+	return codes
+}
+
+func (importer *Importer) isSetSelected(setJson *setJsonStruct) bool {
+	if len(importer.OnlyTheseSetTypes) != 0 && !contains(importer.OnlyTheseSetTypes, setJson.SetType) {
+		return false
+	}
+	if contains(importer.ExcludeTheseSetCodes, setJson.Code) {
+		return false
+	}
+	if importer.OnlyDigitalSets && !setJson.Digital {
+		return false
+	}
+	if importer.OnlyPaperSets && setJson.Digital {
+		return false
+	}
+	if !importer.SetsReleasedAfter.IsZero() || !importer.SetsReleasedBefore.IsZero() {
+		releasedAt, err := time.Parse("2006-01-02", setJson.ReleasedAt)
+		if err != nil {
+			return false
+		}
+		if !importer.SetsReleasedAfter.IsZero() && releasedAt.Before(importer.SetsReleasedAfter) {
+			return false
+		}
+		if !importer.SetsReleasedBefore.IsZero() && releasedAt.After(importer.SetsReleasedBefore) {
+			return false
+		}
+	}
+	return true
+}
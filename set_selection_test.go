@@ -0,0 +1,59 @@
+package mtgdb_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pioz/mtgdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImporterSetSelectors(t *testing.T) {
+	importSets := func(configure func(importer *mtgdb.Importer)) []string {
+		importer := mtgdb.NewImporter(filepath.Join(FIXTURES_PATH, "data"))
+		importer.DownloadAssets = false
+		configure(importer)
+		collection, report, err := importer.BuildCardsFromJson()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, card := range collection {
+			assert.Contains(t, report.Sets, card.SetCode)
+		}
+		return report.Sets
+	}
+
+	sets := importSets(func(importer *mtgdb.Importer) {
+		importer.OnlyTheseSetCodes = []string{"eld"}
+	})
+	assert.Equal(t, []string{"eld"}, sets)
+
+	sets = importSets(func(importer *mtgdb.Importer) {
+		importer.OnlyTheseSetCodes = []string{"eld", "war"}
+		importer.IncludeChildSets = true
+		importer.ExcludeTheseSetCodes = []string{"teld"}
+	})
+	assert.Equal(t, []string{"eld", "peld", "war"}, sets)
+
+	sets = importSets(func(importer *mtgdb.Importer) {
+		importer.OnlyTheseSetTypes = []string{"expansion", "funny"}
+	})
+	assert.Equal(t, []string{"eld", "isd", "ust", "war"}, sets)
+
+	sets = importSets(func(importer *mtgdb.Importer) {
+		importer.SetsReleasedAfter = time.Date(2019, 5, 3, 0, 0, 0, 0, time.UTC)
+		importer.SetsReleasedBefore = time.Date(2019, 10, 4, 0, 0, 0, 0, time.UTC)
+	})
+	assert.Equal(t, []string{"eld", "peld", "teld", "war"}, sets)
+
+	sets = importSets(func(importer *mtgdb.Importer) {
+		importer.OnlyDigitalSets = true
+	})
+	assert.Empty(t, sets)
+
+	sets = importSets(func(importer *mtgdb.Importer) {
+		importer.OnlyPaperSets = true
+	})
+	assert.Equal(t, []string{"eld", "isd", "peld", "sld", "teld", "ust", "war"}, sets)
+}
@@ -1,6 +1,7 @@
 package mtgdb_test
 
 import (
+	"path/filepath"
 	"testing"
 
 	"github.com/pioz/mtgdb"
@@ -14,3 +15,39 @@ func TestSetImagePath(t *testing.T) {
 	}
 	assert.Equal(t, "images/sets/eld.jpg", set.ImagePath("./images"))
 }
+
+func TestImporterSets(t *testing.T) {
+	importer := mtgdb.NewImporter(filepath.Join(FIXTURES_PATH, "data"))
+	importer.DownloadAssets = false
+	_, report, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets := importer.Sets()
+	codes := make([]string, 0, len(sets))
+	for _, set := range sets {
+		codes = append(codes, set.Code)
+	}
+	assert.Equal(t, report.Sets, codes)
+}
+
+func TestDBBulkInsertSets(t *testing.T) {
+	db := openTestDB(t)
+	set := mtgdb.Set{Name: "Throne of Eldraine", Code: "eld", ParentCode: "eld", Typology: "expansion", IconName: "eld"}
+	err := mtgdb.BulkInsertSets(db, []mtgdb.Set{set})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sets are updated also without their cards
+	set.Name = "Throne of Eldraine (fixed)"
+	err = mtgdb.BulkInsertSets(db, []mtgdb.Set{set})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets := make([]mtgdb.Set, 0)
+	db.Find(&sets)
+	if assert.Equal(t, 1, len(sets)) {
+		assert.Equal(t, "Throne of Eldraine (fixed)", sets[0].Name)
+	}
+}
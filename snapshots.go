@@ -0,0 +1,111 @@
+package mtgdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// snapshotNameFormat is the format of the time when a snapshot is archived
+// used as snapshot name, so that snapshots sort by time.
+const snapshotNameFormat = "20060102T150405Z"
+
+// SnapshotsDir returns the dir, inside the data dir, where the data snapshots
+// are archived.
+func SnapshotsDir(dataDir string) string {
+	return filepath.Join(dataDir, "snapshots")
+}
+
+// ListSnapshots returns the names of the archived snapshots, from the oldest
+// to the newest.
+func (importer *Importer) ListSnapshots() ([]string, error) {
+	entries, err := ioutil.ReadDir(SnapshotsDir(importer.DataDir))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			snapshots = append(snapshots, entry.Name())
+		}
+	}
+	sort.Strings(snapshots)
+	return snapshots, nil
+}
+
+// SnapshotTime returns the time when the snapshot was archived.
+func SnapshotTime(snapshot string) (time.Time, error) {
+	return time.Parse(snapshotNameFormat, snapshot)
+}
+
+// archiveSnapshot archives the current data files in a new snapshot and
+// returns its name. Files are hard linked when possible: downloads replace the
+// data files with new ones so the archived files never change.
+func (importer *Importer) archiveSnapshot() (string, error) {
+	snapshot := time.Now().UTC().Format(snapshotNameFormat)
+	snapshotDir := filepath.Join(SnapshotsDir(importer.DataDir), snapshot)
+	err := os.MkdirAll(SnapshotsDir(importer.DataDir), os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+	err = os.Mkdir(snapshotDir, os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+	filePaths := []string{
+		filepath.Join(importer.DataDir, "all_sets.json"),
+		filepath.Join(importer.DataDir, bulkDataMetadataFileName),
+		importer.bulkDataFilePath(importer.BulkDataType),
+		importer.bulkDataFilePath("rulings"),
+	}
+	for _, filePath := range filePaths {
+		err = linkOrCopyFile(filePath, filepath.Join(snapshotDir, filepath.Base(filePath)))
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return snapshot, nil
+}
+
+func linkOrCopyFile(src, dst string) error {
+	err := os.Link(src, dst)
+	if err == nil || os.IsNotExist(err) {
+		return err
+	}
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return writeFileAtomically(dst, file, -1)
+}
+
+// sourceFilePath returns the path of the data file fileName to build the
+// collections from: the one in importer.DataDir or, if importer.Snapshot is
+// set, the one archived in the snapshot.
+func (importer *Importer) sourceFilePath(fileName string) string {
+	if importer.Snapshot == "" {
+		return filepath.Join(importer.DataDir, fileName)
+	}
+	return filepath.Join(SnapshotsDir(importer.DataDir), importer.Snapshot, fileName)
+}
+
+// sourceBulkDataFilePath is like sourceFilePath for the bulk data file of
+// type bulkDataType. The file archived in a snapshot can be compressed or not
+// regardless of importer.CompressBulkData.
+func (importer *Importer) sourceBulkDataFilePath(bulkDataType string) string {
+	if importer.Snapshot == "" {
+		return importer.bulkDataFilePath(bulkDataType)
+	}
+	filePath := importer.sourceFilePath(fmt.Sprintf("%s.json", bulkDataType))
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return filePath + ".gz"
+	}
+	return filePath
+}
@@ -0,0 +1,106 @@
+package mtgdb_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pioz/mtgdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImporterArchiveSnapshots(t *testing.T) {
+	defer os.RemoveAll(TEMP_DIR)
+	server := newScryfallServer()
+	defer server.Close()
+
+	importer := mtgdb.NewImporter(TEMP_DIR)
+	importer.ScryfallApiUrl = server.URL
+	importer.HttpClient = &http.Client{Transport: &scryfallTransport{server: server}}
+	importer.DownloadAssets = false
+	importer.ArchiveSnapshots = true
+	snapshots, err := importer.ListSnapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, snapshots)
+
+	err = importer.DownloadData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err = importer.ListSnapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(snapshots))
+	archivedAt, err := mtgdb.SnapshotTime(snapshots[0])
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now(), archivedAt, time.Minute)
+	for _, fileName := range []string{"all_sets.json", "all_cards.json", "rulings.json", "bulk_data.json"} {
+		_, err = os.Stat(filepath.Join(mtgdb.SnapshotsDir(TEMP_DIR), snapshots[0], fileName))
+		assert.Nil(t, err, fileName)
+	}
+
+	// Nothing downloaded, nothing archived
+	err = importer.DownloadData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err = importer.ListSnapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(snapshots))
+
+	// Replace the current data, as a download does: the snapshot still has
+	// the old one
+	err = os.Remove(filepath.Join(TEMP_DIR, "all_cards.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(TEMP_DIR, "all_cards.json"), []byte("[]"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection, _, err := importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, collection)
+	importer.Snapshot = snapshots[0]
+	collection, _, err = importer.BuildCardsFromJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 10, len(collection))
+
+	importer.Snapshot = "20000101T000000Z"
+	_, _, err = importer.BuildCardsFromJson()
+	assert.Contains(t, err.Error(), "snapshot `20000101T000000Z` not found")
+}
+
+func writeSnapshot(t *testing.T, dataDir, snapshot, allCardsJson string) {
+	snapshotDir := filepath.Join(mtgdb.SnapshotsDir(dataDir), snapshot)
+	err := os.MkdirAll(snapshotDir, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"all_sets.json", "rulings.json"} {
+		content, err := ioutil.ReadFile(filepath.Join(FIXTURES_PATH, "data", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(snapshotDir, name), content, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = ioutil.WriteFile(filepath.Join(snapshotDir, "all_cards.json"), []byte(allCardsJson), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}